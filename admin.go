@@ -0,0 +1,58 @@
+package remotedialer
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Disconnect closes every session for the given client key, delivering the reason to the remote end in the websocket close frame.
+// The client is free to reconnect afterward, see Revoke to prevent that.
+func (s *Server) Disconnect(clientKey, reason string) {
+	s.disconnect(clientKey, websocket.CloseNormalClosure, reason)
+}
+
+// Cordon stops routing new connections to the given client key, while already established connections continue working.
+func (s *Server) Cordon(clientKey string) {
+	s.sessions.setCordoned(clientKey, true)
+}
+
+// Uncordon allows routing new connections to a client key previously cordoned.
+func (s *Server) Uncordon(clientKey string) {
+	s.sessions.setCordoned(clientKey, false)
+}
+
+// Revoke rejects any connection attempt for the given client key until the provided time, disconnecting its current sessions.
+// Revoking with a time in the past lifts an existing revocation.
+func (s *Server) Revoke(clientKey string, until time.Time) {
+	s.revokedLock.Lock()
+	if until.After(time.Now()) {
+		s.revoked[clientKey] = until
+	} else {
+		delete(s.revoked, clientKey)
+	}
+	s.revokedLock.Unlock()
+
+	if until.After(time.Now()) {
+		s.disconnect(clientKey, websocket.ClosePolicyViolation, errRevoked.Error())
+	}
+}
+
+// isRevoked returns whether connections for the given client key are currently rejected
+func (s *Server) isRevoked(clientKey string) bool {
+	s.revokedLock.Lock()
+	defer s.revokedLock.Unlock()
+
+	until, ok := s.revoked[clientKey]
+	if ok && !time.Now().Before(until) {
+		delete(s.revoked, clientKey)
+		return false
+	}
+	return ok
+}
+
+func (s *Server) disconnect(clientKey string, code int, reason string) {
+	for _, session := range s.sessions.getSessions(clientKey) {
+		session.disconnect(code, reason)
+	}
+}
@@ -0,0 +1,106 @@
+package remotedialer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestServer_Disconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverAddress, server, err := newTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connected := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- ConnectToProxy(ctx, "ws://"+serverAddress, nil, func(string, string) bool { return true }, nil, func(context.Context, *Session) error {
+			close(connected)
+			return nil
+		})
+	}()
+	<-connected
+	if err := server.WaitForSession(ctx, "client"); err != nil {
+		t.Fatal(err)
+	}
+
+	const reason = "credentials rotated"
+	server.Disconnect("client", reason)
+
+	select {
+	case err := <-result:
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("unexpected error, got: %v, want a close error", err)
+		}
+		if got, want := closeErr.Text, reason; got != want {
+			t.Errorf("incorrect close reason, got: %q, want: %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("client was not disconnected")
+	}
+}
+
+func TestServer_Cordon(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverAddress, server, err := newTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newTestClient(ctx, "ws://"+serverAddress); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.WaitForSession(ctx, "client"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Cordon("client")
+	if !server.HasSession("client") {
+		t.Error("cordoned client should still have a session")
+	}
+	if _, err := server.Dialer("client")(ctx, "tcp", "localhost:0"); err == nil {
+		t.Error("dialing a cordoned client should fail")
+	}
+
+	server.Uncordon("client")
+	if err := server.WaitForSession(ctx, "client"); err != nil {
+		t.Errorf("uncordoned client should be available: %v", err)
+	}
+}
+
+func TestServer_Revoke(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverAddress, server, err := newTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.Revoke("client", time.Now().Add(time.Hour))
+	_, resp, err := websocket.DefaultDialer.DialContext(ctx, "ws://"+serverAddress, nil)
+	if err == nil {
+		t.Fatal("connection from a revoked client should fail")
+	}
+	if got, want := resp.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("incorrect status code, got: %d, want: %d", got, want)
+	}
+
+	server.Revoke("client", time.Time{})
+	if err := newTestClient(ctx, "ws://"+serverAddress); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.WaitForSession(ctx, "client"); err != nil {
+		t.Errorf("client should be able to connect after lifting the revocation: %v", err)
+	}
+}
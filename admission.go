@@ -0,0 +1,237 @@
+package remotedialer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Connection limit scopes, as reported in ConnectionLimitError and metrics
+const (
+	admissionScopeClient  = "client"
+	admissionScopeSession = "session"
+)
+
+// ErrConnectionLimit is wrapped by the ConnectionLimitError returned when a connection is rejected by connection limits
+var ErrConnectionLimit = errors.New("connection limit exceeded")
+
+// ConnectionLimitError is returned by dials rejected by the connection limits of either end.
+// It is temporary: the dial can be retried later, once other connections are closed.
+type ConnectionLimitError struct {
+	// Limit describes the exceeded limit, such as "max connections per session"
+	Limit string
+	// Remote is true if the connection was rejected by the remote end
+	Remote bool
+}
+
+func (e *ConnectionLimitError) Error() string {
+	return ErrConnectionLimit.Error() + ": " + e.Limit
+}
+
+func (e *ConnectionLimitError) Unwrap() error {
+	return ErrConnectionLimit
+}
+
+// Temporary implements net.Error, the connection can always be retried later
+func (e *ConnectionLimitError) Temporary() bool {
+	return true
+}
+
+// Timeout implements net.Error
+func (e *ConnectionLimitError) Timeout() bool {
+	return false
+}
+
+// parseConnectionLimitError returns the ConnectionLimitError sent by the remote end in an Error message, if any
+func parseConnectionLimitError(msg string) (*ConnectionLimitError, bool) {
+	limit, ok := strings.CutPrefix(msg, ErrConnectionLimit.Error()+": ")
+	if !ok {
+		return nil, false
+	}
+	return &ConnectionLimitError{Limit: limit, Remote: true}, true
+}
+
+// ConnectionLimits bounds the number and rate of the connections of a session or client key.
+// The zero value means no limit.
+type ConnectionLimits struct {
+	// MaxConnections is the maximum number of concurrent connections, unlimited if zero
+	MaxConnections int
+	// ConnectsPerSecond is the maximum rate of new connections, unlimited if zero
+	ConnectsPerSecond float64
+	// ConnectBurst is the number of connections that can be opened at once above ConnectsPerSecond, 1 if zero
+	ConnectBurst int
+	// QueueSize is the number of dials waiting for a connection to be closed once MaxConnections is reached.
+	// Dials are rejected right away if zero. Connections requested by the remote end are never queued.
+	QueueSize int
+	// QueueTimeout bounds the time spent by a dial in the queue, in addition to its context. Only the context applies if zero.
+	QueueTimeout time.Duration
+}
+
+func (l ConnectionLimits) limited() bool {
+	return l.MaxConnections > 0 || l.ConnectsPerSecond > 0
+}
+
+// connAdmission enforces ConnectionLimits, it's nil when there are no limits
+type connAdmission struct {
+	scope  string
+	limits ConnectionLimits
+	rate   *rate.Limiter
+
+	lock    sync.Mutex
+	active  int
+	waiting int
+	// released is closed and replaced every time a connection is released, waking up the queued dials
+	released chan struct{}
+}
+
+func newConnAdmission(scope string, limits ConnectionLimits) *connAdmission {
+	if !limits.limited() {
+		return nil
+	}
+	a := &connAdmission{
+		scope:    scope,
+		limits:   limits,
+		released: make(chan struct{}),
+	}
+	if limits.ConnectsPerSecond > 0 {
+		a.rate = rate.NewLimiter(rate.Limit(limits.ConnectsPerSecond), max(limits.ConnectBurst, 1))
+	}
+	return a
+}
+
+// acquire takes a connection slot, waiting in the queue for one to be released if queue is true.
+// release must be called once the connection is closed.
+func (a *connAdmission) acquire(ctx context.Context, queue bool) error {
+	if a == nil {
+		return nil
+	}
+	if err := a.acquireSlot(ctx, queue); err != nil {
+		return err
+	}
+	if a.rate != nil && !a.rate.Allow() {
+		a.release()
+		return a.limitError("connect rate")
+	}
+	return nil
+}
+
+func (a *connAdmission) acquireSlot(ctx context.Context, queue bool) error {
+	a.lock.Lock()
+	if a.available() {
+		a.active++
+		a.lock.Unlock()
+		return nil
+	}
+	if !queue || a.waiting >= a.limits.QueueSize {
+		a.lock.Unlock()
+		return a.limitError("max connections")
+	}
+	a.waiting++
+	a.lock.Unlock()
+
+	if a.limits.QueueTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.limits.QueueTimeout)
+		defer cancel()
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	defer func() {
+		a.waiting--
+	}()
+	for !a.available() {
+		released := a.released
+		a.lock.Unlock()
+		select {
+		case <-released:
+			a.lock.Lock()
+		case <-ctx.Done():
+			a.lock.Lock()
+			return a.limitError("max connections")
+		}
+	}
+	a.active++
+	return nil
+}
+
+// available reports whether a connection can be admitted, the lock must be held by the caller
+func (a *connAdmission) available() bool {
+	return a.limits.MaxConnections <= 0 || a.active < a.limits.MaxConnections
+}
+
+func (a *connAdmission) release() {
+	if a == nil {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.active--
+	close(a.released)
+	a.released = make(chan struct{})
+}
+
+func (a *connAdmission) limitError(limit string) error {
+	return &ConnectionLimitError{Limit: limit + " per " + a.scope}
+}
+
+// admit takes a connection slot in the limits of the session and its client key, returning the function releasing it.
+// Dials initiated by this end can wait in the queue, connections requested by the remote end are rejected right away.
+func (s *Session) admit(ctx context.Context, queue bool) (func(), error) {
+	if err := s.clientAdmission.acquire(ctx, queue); err != nil {
+		s.connectionRejected(err)
+		return nil, err
+	}
+	if err := s.admission.acquire(ctx, queue); err != nil {
+		s.clientAdmission.release()
+		s.connectionRejected(err)
+		return nil, err
+	}
+	return func() {
+		s.admission.release()
+		s.clientAdmission.release()
+	}, nil
+}
+
+func (s *Session) connectionRejected(err error) {
+	var limitErr *ConnectionLimitError
+	if errors.As(err, &limitErr) {
+		s.metrics.ConnectionRejected(s.clientKey, limitErr.Limit)
+	}
+	s.logger.Debug("Connection rejected", "error", err)
+}
+
+// clientAdmission returns the connection limits shared by the sessions of a client key, or nil if it's not limited.
+// Every non-nil connection limits returned must be released with releaseClientAdmission.
+func (s *Server) clientAdmission(clientKey string) *connAdmission {
+	s.admissionLock.Lock()
+	defer s.admissionLock.Unlock()
+
+	if a, ok := s.clientAdmissions[clientKey]; ok {
+		s.clientAdmissionRefs[clientKey]++
+		return a
+	}
+	a := newConnAdmission(admissionScopeClient, s.ClientConnectionLimits)
+	if a != nil {
+		s.clientAdmissions[clientKey] = a
+		s.clientAdmissionRefs[clientKey] = 1
+	}
+	return a
+}
+
+// releaseClientAdmission forgets the connection limits of a client key once the last session using them is released,
+// so a session reconnecting before the previous one is gone keeps sharing the same limits
+func (s *Server) releaseClientAdmission(clientKey string) {
+	s.admissionLock.Lock()
+	defer s.admissionLock.Unlock()
+
+	if s.clientAdmissionRefs[clientKey]--; s.clientAdmissionRefs[clientKey] <= 0 {
+		delete(s.clientAdmissionRefs, clientKey)
+		delete(s.clientAdmissions, clientKey)
+	}
+}
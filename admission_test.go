@@ -0,0 +1,184 @@
+package remotedialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnAdmission(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	a := newConnAdmission(admissionScopeSession, ConnectionLimits{MaxConnections: 1, QueueSize: 1, QueueTimeout: 100 * time.Millisecond})
+	if err := a.acquire(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	err := a.acquire(ctx, false)
+	var limitErr *ConnectionLimitError
+	if !errors.As(err, &limitErr) || !errors.Is(err, ErrConnectionLimit) {
+		t.Fatalf("expected a ConnectionLimitError, got: %v", err)
+	}
+	if got, want := limitErr.Limit, "max connections per session"; got != want {
+		t.Errorf("incorrect limit, got: %q, want: %q", got, want)
+	}
+	if netErr, ok := err.(net.Error); !ok || !netErr.Temporary() {
+		t.Errorf("expected a temporary net.Error, got: %v", err)
+	}
+
+	// the queue is bounded by its timeout
+	start := time.Now()
+	if err := a.acquire(ctx, true); !errors.Is(err, ErrConnectionLimit) {
+		t.Errorf("expected the queued dial to time out, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("queued dial returned too early, after %s", elapsed)
+	}
+
+	queued := make(chan error, 1)
+	go func() {
+		queued <- a.acquire(ctx, true)
+	}()
+	// wait for the dial to be queued, the next one can't fit in the queue anymore
+	for {
+		a.lock.Lock()
+		waiting := a.waiting
+		a.lock.Unlock()
+		if waiting == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := a.acquire(ctx, true); !errors.Is(err, ErrConnectionLimit) {
+		t.Errorf("expected the dial to be rejected with a full queue, got: %v", err)
+	}
+
+	a.release()
+	if err := <-queued; err != nil {
+		t.Errorf("expected the queued dial to be admitted, got: %v", err)
+	}
+}
+
+func TestConnAdmission_ConnectRate(t *testing.T) {
+	t.Parallel()
+
+	a := newConnAdmission(admissionScopeClient, ConnectionLimits{ConnectsPerSecond: 0.1, ConnectBurst: 2})
+	for range 2 {
+		if err := a.acquire(context.Background(), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var limitErr *ConnectionLimitError
+	if err := a.acquire(context.Background(), true); !errors.As(err, &limitErr) || limitErr.Limit != "connect rate per client" {
+		t.Errorf("expected the connect rate to be exceeded, got: %v", err)
+	}
+	if a.active != 2 {
+		t.Errorf("rejected connections should not take a slot, got %d active connections", a.active)
+	}
+}
+
+func TestServer_SessionConnectionLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := ClientOptions{
+		LocalDialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go func() {
+				<-ctx.Done()
+				server.Close()
+			}()
+			return client, nil
+		},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, func(server *Server) {
+		server.SessionConnectionLimits = ConnectionLimits{MaxConnections: 1}
+	})
+
+	dialer := server.Dialer("client")
+	conn, err := dialer(ctx, "tcp", "downstream:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var limitErr *ConnectionLimitError
+	if _, err := dialer(ctx, "tcp", "downstream:443"); !errors.As(err, &limitErr) || limitErr.Remote {
+		t.Fatalf("expected the dial to be rejected locally, got: %v", err)
+	}
+
+	conn.Close()
+	conn, err = dialer(ctx, "tcp", "downstream:443")
+	if err != nil {
+		t.Fatalf("expected the connection slot to be released, got: %v", err)
+	}
+	conn.Close()
+}
+
+func TestClientConnectionLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := ClientOptions{
+		LocalDialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go func() {
+				<-ctx.Done()
+				server.Close()
+			}()
+			return client, nil
+		},
+		ConnectionLimits: ConnectionLimits{MaxConnections: 1},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, nil)
+
+	dialer := server.Dialer("client")
+	conn, err := dialer(ctx, "tcp", "downstream:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the client rejects the connection after it was sent, the error is returned on first use
+	rejected, err := dialer(ctx, "tcp", "downstream:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rejected.Close()
+	_ = rejected.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = rejected.Read(make([]byte, 1))
+	var limitErr *ConnectionLimitError
+	if !errors.As(err, &limitErr) || !limitErr.Remote || limitErr.Limit != "max connections per session" {
+		t.Errorf("expected the connection to be rejected by the client, got: %v", err)
+	}
+}
+
+func TestServer_ClientAdmission(t *testing.T) {
+	t.Parallel()
+
+	server := New(nil, DefaultErrorWriter)
+	if server.clientAdmission("unlimited") != nil {
+		t.Error("expected no connection limits without a limit")
+	}
+
+	server.ClientConnectionLimits = ConnectionLimits{MaxConnections: 1}
+	a := server.clientAdmission("client")
+	if a == nil {
+		t.Fatal("expected connection limits for the client")
+	}
+
+	// a reconnecting session acquires the limits before the previous one releases them
+	reconnected := server.clientAdmission("client")
+	server.releaseClientAdmission("client")
+	if reconnected != a || server.clientAdmission("client") != a {
+		t.Error("expected the connection limits to be shared until their last session is released")
+	}
+
+	server.releaseClientAdmission("client")
+	server.releaseClientAdmission("client")
+	if server.clientAdmission("client") == a {
+		t.Error("expected the connection limits to be released without sessions")
+	}
+}
@@ -0,0 +1,119 @@
+package remotedialer
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// AuditEventType identifies the lifecycle point of a connection an AuditEvent was emitted at
+type AuditEventType string
+
+const (
+	// AuditConnectionOpened is emitted when a connection is registered in a session, before being dialed
+	AuditConnectionOpened AuditEventType = "connection.opened"
+	// AuditConnectionClosed is emitted once, when a connection is closed
+	AuditConnectionClosed AuditEventType = "connection.closed"
+)
+
+// AuditEvent records the lifecycle of a tunneled connection
+type AuditEvent struct {
+	Type       AuditEventType `json:"type"`
+	ClientKey  string         `json:"clientKey"`
+	SessionKey int64          `json:"sessionKey"`
+	ConnID     int64          `json:"connID"`
+	Proto      string         `json:"proto"`
+	Address    string         `json:"address"`
+	// Outbound is true when the connection was requested by this end, false when requested by the remote end
+	Outbound bool `json:"outbound"`
+	// Caller is the caller set in the dial context with ContextKeyCaller, see ValueFromContext.
+	// Connections requested by the remote end get the caller it sent, see DialMetadataCaller.
+	Caller   string       `json:"caller,omitempty"`
+	Metadata DialMetadata `json:"metadata,omitempty"`
+	Start    time.Time    `json:"start"`
+	// End, BytesIn, BytesOut and CloseReason are only set for AuditConnectionClosed events
+	End         time.Time `json:"end,omitzero"`
+	BytesIn     int64     `json:"bytesIn"`
+	BytesOut    int64     `json:"bytesOut"`
+	CloseReason string    `json:"closeReason,omitempty"`
+}
+
+// AuditSink receives the audit events of every connection.
+// Record is called synchronously from the session goroutines, so implementations must be fast and concurrency-safe.
+type AuditSink interface {
+	Record(event AuditEvent)
+}
+
+func (c *connection) auditEvent(eventType AuditEventType) AuditEvent {
+	return AuditEvent{
+		Type:       eventType,
+		ClientKey:  c.session.clientKey,
+		SessionKey: c.session.sessionKey,
+		ConnID:     c.connID,
+		Proto:      c.addr.proto,
+		Address:    c.addr.address,
+		Outbound:   c.outbound,
+		Caller:     c.caller,
+		Metadata:   c.metadata,
+		Start:      c.created,
+	}
+}
+
+func (c *connection) auditOpened() {
+	if c.session.audit != nil {
+		c.session.audit.Record(c.auditEvent(AuditConnectionOpened))
+	}
+}
+
+func (c *connection) auditClosed(err error) {
+	if c.session.audit == nil {
+		return
+	}
+	event := c.auditEvent(AuditConnectionClosed)
+	event.End = time.Now()
+	stats := c.Stats()
+	event.BytesIn, event.BytesOut = stats.BytesIn, stats.BytesOut
+	if err != nil {
+		event.CloseReason = err.Error()
+	}
+	c.session.audit.Record(event)
+}
+
+// JSONLinesAuditSink is an AuditSink writing every event as a JSON object on its own line
+type JSONLinesAuditSink struct {
+	lock    sync.Mutex
+	w       io.Writer
+	encoder *json.Encoder
+	err     error
+}
+
+// NewJSONLinesAuditSink creates a JSONLinesAuditSink writing to w, such as a file opened in append mode
+func NewJSONLinesAuditSink(w io.Writer) *JSONLinesAuditSink {
+	return &JSONLinesAuditSink{w: w, encoder: json.NewEncoder(w)}
+}
+
+func (s *JSONLinesAuditSink) Record(event AuditEvent) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.encoder.Encode(event); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// Err returns the first error encountered while writing events, if any
+func (s *JSONLinesAuditSink) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// Close closes the underlying writer if it's an io.Closer
+func (s *JSONLinesAuditSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if closer, ok := s.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
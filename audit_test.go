@@ -0,0 +1,108 @@
+package remotedialer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testAuditSink struct {
+	sync.Mutex
+	events []AuditEvent
+}
+
+func (s *testAuditSink) Record(event AuditEvent) {
+	s.Lock()
+	defer s.Unlock()
+	s.events = append(s.events, event)
+}
+
+func (s *testAuditSink) closed() []AuditEvent {
+	s.Lock()
+	defer s.Unlock()
+	var res []AuditEvent
+	for _, event := range s.events {
+		if event.Type == AuditConnectionClosed {
+			res = append(res, event)
+		}
+	}
+	return res
+}
+
+func TestAuditEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var serverOutput lockedBuffer
+
+	clientSink := &testAuditSink{}
+	opts := ClientOptions{
+		AuditSink: clientSink,
+		LocalDialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				_, _ = io.Copy(server, server)
+			}()
+			return client, nil
+		},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, func(server *Server) {
+		server.AuditSink = NewJSONLinesAuditSink(&serverOutput)
+	})
+
+	dialCtx := WithDialMetadata(context.WithValue(ctx, ContextKeyCaller, "audit-test"), DialMetadata{"user": "alice"})
+	conn, err := server.Dialer("client")(dialCtx, "tcp", "downstream:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(clientSink.closed()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("client did not emit a closed event")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	serverOutput.Lock()
+	var serverEvents []AuditEvent
+	scanner := bufio.NewScanner(bytes.NewReader(serverOutput.Bytes()))
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		serverEvents = append(serverEvents, event)
+	}
+	serverOutput.Unlock()
+
+	if len(serverEvents) != 2 || serverEvents[0].Type != AuditConnectionOpened || serverEvents[1].Type != AuditConnectionClosed {
+		t.Fatalf("unexpected server events: %+v", serverEvents)
+	}
+	closed := serverEvents[1]
+	if !closed.Outbound || closed.Caller != "audit-test" || closed.Metadata["user"] != "alice" || closed.Address != "downstream:443" {
+		t.Errorf("unexpected server closed event: %+v", closed)
+	}
+	if closed.BytesOut != 5 || closed.BytesIn != 5 || closed.CloseReason != io.EOF.Error() || closed.End.Before(closed.Start) {
+		t.Errorf("unexpected server closed event: %+v", closed)
+	}
+
+	clientClosed := clientSink.closed()[0]
+	if clientClosed.Outbound || clientClosed.Caller != "audit-test" || clientClosed.Metadata["user"] != "alice" || clientClosed.ConnID != closed.ConnID {
+		t.Errorf("unexpected client closed event: %+v", clientClosed)
+	}
+}
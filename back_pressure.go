@@ -3,6 +3,7 @@ package remotedialer
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 type backPressure struct {
@@ -10,6 +11,11 @@ type backPressure struct {
 	c      *connection
 	paused bool
 	closed bool
+	// pausedAt is the time the remote end paused this connection
+	pausedAt time.Time
+	// pausedSince is the time this connection was paused by either end, if limited by MaxPausedDuration
+	pausedSince time.Time
+	pauseTimer  *time.Timer
 }
 
 func newBackPressure(c *connection) *backPressure {
@@ -26,6 +32,10 @@ func (b *backPressure) OnPause() {
 	b.cond.L.Lock()
 	defer b.cond.L.Unlock()
 
+	if !b.paused {
+		b.pausedAt = time.Now()
+		b.startPauseTimer()
+	}
 	b.paused = true
 	b.cond.Broadcast()
 }
@@ -35,6 +45,7 @@ func (b *backPressure) Close() {
 	defer b.cond.L.Unlock()
 
 	b.closed = true
+	b.stopPauseTimer()
 	b.cond.Broadcast()
 }
 
@@ -42,6 +53,11 @@ func (b *backPressure) OnResume() {
 	b.cond.L.Lock()
 	defer b.cond.L.Unlock()
 
+	if !b.pausedAt.IsZero() {
+		b.c.session.metrics.Paused(b.c.session.clientKey, time.Since(b.pausedAt))
+		b.pausedAt = time.Time{}
+	}
+	b.stopPauseTimer()
 	b.paused = false
 	b.cond.Broadcast()
 }
@@ -53,6 +69,7 @@ func (b *backPressure) Pause() {
 		return
 	}
 	b.c.Pause()
+	b.startPauseTimer()
 	b.paused = true
 }
 
@@ -63,9 +80,17 @@ func (b *backPressure) Resume() {
 		return
 	}
 	b.c.Resume()
+	b.stopPauseTimer()
 	b.paused = false
 }
 
+func (b *backPressure) isPaused() bool {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	return b.paused
+}
+
 func (b *backPressure) Wait(cancel context.CancelFunc) {
 	b.cond.L.Lock()
 	defer b.cond.L.Unlock()
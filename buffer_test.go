@@ -108,6 +108,25 @@ func newServer(ctx context.Context, handler http.Handler) (string, error) {
 	return listener.Addr().String(), nil
 }
 
+// newTestClientWithOptions starts a test server, configured by configure if not nil, and connects a client session
+// allowing every connection with opts. It returns once the session is registered by the server.
+func newTestClientWithOptions(ctx context.Context, t *testing.T, opts ClientOptions, configure func(*Server)) *Server {
+	t.Helper()
+
+	serverAddress, server, err := newTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configure != nil {
+		configure(server)
+	}
+	go ConnectToProxyWithOptions(ctx, "ws://"+serverAddress, nil, func(string, string) bool { return true }, nil, opts, nil)
+	if err := server.WaitForSession(ctx, "client"); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
 func newTestProducer(ctx context.Context) (string, error) {
 	buffer := make([]byte, 4096)
 	return newServer(ctx, http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
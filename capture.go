@@ -0,0 +1,300 @@
+package remotedialer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// captureMagic is written at the beginning of every capture
+var captureMagic = []byte("RDCAP\x01")
+
+var errCaptureInProgress = errors.New("capture already in progress")
+
+// captureQueueSize is the number of frames waiting to be written to a capture, further frames are dropped.
+// Frames are recorded by the tunnel I/O, which must never wait for a slow capture writer.
+const captureQueueSize = 1024
+
+// CaptureDirection tells whether a captured frame was sent or received
+type CaptureDirection uint8
+
+const (
+	// CaptureRead is used for frames received from the remote end
+	CaptureRead CaptureDirection = iota
+	// CaptureWrite is used for frames sent to the remote end
+	CaptureWrite
+	// CaptureDropped is used for the records counting the frames dropped because the capture writer couldn't keep up
+	CaptureDropped
+)
+
+func (d CaptureDirection) String() string {
+	switch d {
+	case CaptureWrite:
+		return "WRITE"
+	case CaptureDropped:
+		return "DROPPED"
+	}
+	return "READ"
+}
+
+// capture writes raw frames to an io.Writer.
+// Every record contains the timestamp, session key, direction, length and raw bytes of a frame.
+// Frames are queued and written by a separate goroutine, frames dropped while the queue is full are counted in a CaptureDropped record.
+type capture struct {
+	w     io.Writer
+	queue chan []byte
+	// dropped is the number of frames dropped since the last CaptureDropped record
+	dropped atomic.Int64
+	done    chan struct{}
+
+	// lock guards sending to the queue against closing it
+	lock   sync.RWMutex
+	closed bool
+}
+
+func newCapture(w io.Writer) (*capture, error) {
+	if _, err := w.Write(captureMagic); err != nil {
+		return nil, err
+	}
+	c := &capture{
+		w:     w,
+		queue: make(chan []byte, captureQueueSize),
+		done:  make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+func captureRecord(sessionKey int64, direction CaptureDirection, frame []byte) []byte {
+	record := make([]byte, 0, 21+len(frame))
+	record = binary.LittleEndian.AppendUint64(record, uint64(time.Now().UnixNano()))
+	record = binary.LittleEndian.AppendUint64(record, uint64(sessionKey))
+	record = append(record, byte(direction))
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(frame)))
+	return append(record, frame...)
+}
+
+// record queues a frame to be appended to the capture, dropping it if the queue is full
+func (c *capture) record(sessionKey int64, direction CaptureDirection, frame []byte) {
+	record := captureRecord(sessionKey, direction, frame)
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.queue <- record:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// run writes the queued frames until the capture is closed. Once writing fails, any subsequent frame is discarded.
+func (c *capture) run() {
+	defer close(c.done)
+
+	var err error
+	for record := range c.queue {
+		if err == nil {
+			err = c.writeDropped()
+		}
+		if err == nil {
+			_, err = c.w.Write(record)
+		}
+	}
+	if err == nil {
+		_ = c.writeDropped()
+	}
+}
+
+// writeDropped writes a CaptureDropped record if any frame was dropped since the last one
+func (c *capture) writeDropped() error {
+	n := c.dropped.Swap(0)
+	if n == 0 {
+		return nil
+	}
+	_, err := c.w.Write(captureRecord(0, CaptureDropped, binary.LittleEndian.AppendUint64(nil, uint64(n))))
+	return err
+}
+
+// close stops the capture once the queued frames are written, a session still holding a reference to it won't be able
+// to record any more frames
+func (c *capture) close() error {
+	c.lock.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	c.lock.Unlock()
+
+	<-c.done
+	if closer, ok := c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// StartCapture records every frame sent or received by this session to w, until StopCapture is called.
+// Frames are dropped rather than slowing down the session if w can't keep up, see CaptureDropped.
+func (s *Session) StartCapture(w io.Writer) error {
+	c, err := newCapture(w)
+	if err != nil {
+		return err
+	}
+	if !s.capture.CompareAndSwap(nil, c) {
+		return errCaptureInProgress
+	}
+	return nil
+}
+
+// StopCapture stops recording the frames of this session, closing the writer passed to StartCapture if it's an io.Closer.
+// It returns once the frames already recorded are written.
+func (s *Session) StopCapture() error {
+	if c := s.capture.Swap(nil); c != nil {
+		return c.close()
+	}
+	return nil
+}
+
+// StartCapture records every frame sent or received by the sessions of the given client key to w, including sessions
+// connected after this call, until StopCapture is called.
+func (s *Server) StartCapture(clientKey string, w io.Writer) error {
+	s.captureLock.Lock()
+	defer s.captureLock.Unlock()
+	if _, ok := s.captures[clientKey]; ok {
+		return errCaptureInProgress
+	}
+
+	c, err := newCapture(w)
+	if err != nil {
+		return err
+	}
+	s.captures[clientKey] = c
+	for _, session := range s.sessions.getSessions(clientKey) {
+		session.capture.Store(c)
+	}
+	return nil
+}
+
+// StopCapture stops recording the frames for the given client key, closing the writer passed to StartCapture if it's an io.Closer.
+// It returns once the frames already recorded are written.
+func (s *Server) StopCapture(clientKey string) error {
+	s.captureLock.Lock()
+	c, ok := s.captures[clientKey]
+	if !ok {
+		s.captureLock.Unlock()
+		return nil
+	}
+	delete(s.captures, clientKey)
+	for _, session := range s.sessions.getSessions(clientKey) {
+		session.capture.CompareAndSwap(c, nil)
+	}
+	s.captureLock.Unlock()
+
+	// a slow writer must not block new sessions, which look up the captures in progress
+	return c.close()
+}
+
+// startSessionCapture attaches to a new session the capture in progress for its client key, if any
+func (s *Server) startSessionCapture(session *Session) {
+	s.captureLock.Lock()
+	defer s.captureLock.Unlock()
+
+	if c, ok := s.captures[session.clientKey]; ok {
+		session.capture.Store(c)
+	}
+}
+
+// CapturedFrame is a frame decoded from a capture
+type CapturedFrame struct {
+	Time      time.Time
+	Direction CaptureDirection
+	FrameInfo
+	// Error holds the reason sent in Error messages
+	Error string
+	// Dropped is the number of frames dropped at this point of the capture, for CaptureDropped records
+	Dropped int64
+}
+
+// ReadCapture decodes every frame from a capture created by StartCapture, calling fn for each of them
+func ReadCapture(r io.Reader, fn func(CapturedFrame) error) error {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return fmt.Errorf("reading capture header: %w", err)
+	}
+	if !bytes.Equal(magic, captureMagic) {
+		return errors.New("invalid capture header")
+	}
+
+	header := make([]byte, 21)
+	for {
+		if _, err := io.ReadFull(br, header); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading frame header: %w", err)
+		}
+
+		frame := make([]byte, binary.LittleEndian.Uint32(header[17:21]))
+		if _, err := io.ReadFull(br, frame); err != nil {
+			return fmt.Errorf("reading frame: %w", err)
+		}
+
+		captured := CapturedFrame{
+			Time:      time.Unix(0, int64(binary.LittleEndian.Uint64(header[0:8]))),
+			Direction: CaptureDirection(header[16]),
+		}
+		if captured.Direction == CaptureDropped {
+			if len(frame) != 8 {
+				return errors.New("invalid dropped frames record")
+			}
+			captured.Dropped = int64(binary.LittleEndian.Uint64(frame))
+		} else if err := decodeCapturedFrame(&captured, int64(binary.LittleEndian.Uint64(header[8:16])), frame); err != nil {
+			return err
+		}
+		if err := fn(captured); err != nil {
+			return err
+		}
+	}
+}
+
+func decodeCapturedFrame(captured *CapturedFrame, sessionKey int64, frame []byte) error {
+	m, err := newServerMessage(bytes.NewReader(frame))
+	if err != nil {
+		return fmt.Errorf("decoding frame: %w", err)
+	}
+
+	if m.messageType == Error {
+		captured.Error = m.Err().Error()
+	}
+	size := len(m.bytes)
+	if m.messageType == Data || m.messageType == SyncConnections || m.messageType == SyncConnectionRanges {
+		n, err := io.Copy(io.Discard, m.body)
+		if err != nil {
+			return fmt.Errorf("decoding frame: %w", err)
+		}
+		size = int(n)
+	}
+
+	captured.FrameInfo = FrameInfo{
+		SessionKey: sessionKey,
+		MessageID:  m.id,
+		ConnID:     m.connID,
+		Type:       m.messageType.String(),
+		Size:       size,
+	}
+	if m.messageType == Connect || m.messageType == Listen {
+		captured.Proto, captured.Address = m.proto, m.address
+	} else if m.messageType == AddClient || m.messageType == RemoveClient || m.messageType == ListenReady || m.messageType == ListenAccept {
+		captured.Address = m.address
+	}
+	return nil
+}
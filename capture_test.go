@@ -0,0 +1,180 @@
+package remotedialer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type lockedBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func TestServer_Capture(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	echo, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	serverAddress, server, err := newTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf lockedBuffer
+	if err := server.StartCapture("client", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.StartCapture("client", io.Discard); err == nil {
+		t.Error("expected an error starting a second capture for the same client")
+	}
+
+	if err := newTestClient(ctx, "ws://"+serverAddress); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.WaitForSession(ctx, "client"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := server.Dialer("client")(ctx, "tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	connID := conn.(*connection).connID
+	conn.Close()
+
+	if err := server.StopCapture("client"); err != nil {
+		t.Fatal(err)
+	}
+
+	var frames []CapturedFrame
+	buf.Lock()
+	defer buf.Unlock()
+	if err := ReadCapture(&buf.Buffer, func(frame CapturedFrame) error {
+		if frame.ConnID == connID {
+			frames = append(frames, frame)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(frames) < 3 {
+		t.Fatalf("expected at least 3 frames for connection %d, got: %v", connID, frames)
+	}
+	if got := frames[0]; got.Type != "CONNECT" || got.Direction != CaptureWrite || got.Address != echo.Addr().String() {
+		t.Errorf("unexpected first frame: %+v", got)
+	}
+
+	var written, read int
+	for _, frame := range frames {
+		if frame.Type != "DATA" {
+			continue
+		}
+		if frame.Direction == CaptureWrite {
+			written += frame.Size
+		} else {
+			read += frame.Size
+		}
+	}
+	if written != 5 || read != 5 {
+		t.Errorf("unexpected data sizes, got: %d written and %d read, want: 5 and 5", written, read)
+	}
+}
+
+func TestReadCaptureInvalidHeader(t *testing.T) {
+	if err := ReadCapture(bytes.NewReader([]byte("not a capture")), func(CapturedFrame) error { return nil }); err == nil {
+		t.Error("expected an error reading an invalid capture")
+	}
+}
+
+// blockingWriter blocks every write after the first one until unblocked
+type blockingWriter struct {
+	lockedBuffer
+	writes  int
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	if w.writes++; w.writes > 1 {
+		<-w.unblock
+	}
+	return w.lockedBuffer.Write(p)
+}
+
+func TestCaptureSlowWriter(t *testing.T) {
+	w := &blockingWriter{unblock: make(chan struct{})}
+	c, err := newCapture(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := make(chan struct{})
+	go func() {
+		defer close(recorded)
+		for range captureQueueSize * 2 {
+			c.record(1, CaptureWrite, newMessage(1, []byte("data")).Bytes())
+		}
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recording frames was blocked by the capture writer")
+	}
+
+	close(w.unblock)
+	if err := c.close(); err != nil {
+		t.Fatal(err)
+	}
+	var frames, dropped int64
+	if err := ReadCapture(&w.Buffer, func(frame CapturedFrame) error {
+		if frame.Direction == CaptureDropped {
+			dropped += frame.Dropped
+		} else {
+			frames++
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if dropped == 0 {
+		t.Error("expected the frames exceeding the queue to be reported as dropped")
+	}
+	if frames+dropped != captureQueueSize*2 {
+		t.Errorf("expected every frame to be either written or reported as dropped, got %d written and %d dropped", frames, dropped)
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/sirupsen/logrus"
 )
 
 // ConnectAuthorizer custom for authorization
@@ -20,7 +19,7 @@ func ClientConnect(ctx context.Context, wsURL string, headers http.Header, diale
 	auth ConnectAuthorizer, onConnect func(context.Context, *Session) error) error {
 	if err := ConnectToProxy(ctx, wsURL, headers, auth, dialer, onConnect); err != nil {
 		if !errors.Is(err, context.Canceled) {
-			logrus.WithError(err).Error("Remotedialer proxy error")
+			defaultLogger().Error("Remotedialer proxy error", "error", err)
 			time.Sleep(time.Duration(5) * time.Second)
 		}
 		return err
@@ -37,23 +36,31 @@ func ConnectToProxy(rootCtx context.Context, proxyURL string, headers http.Heade
 // ConnectToProxyWithDialer connects to the websocket server.
 // Local connections on behalf of the remote host will be dialed using the provided Dialer function.
 func ConnectToProxyWithDialer(rootCtx context.Context, proxyURL string, headers http.Header, auth ConnectAuthorizer, dialer *websocket.Dialer, localDialer Dialer, onConnect func(context.Context, *Session) error) error {
-	logrus.WithField("url", proxyURL).Info("Connecting to proxy")
+	return ConnectToProxyWithOptions(rootCtx, proxyURL, headers, auth, dialer, ClientOptions{LocalDialer: localDialer}, onConnect)
+}
+
+// ConnectToProxyWithOptions connects to the websocket server.
+// The client session is configured using the provided options.
+func ConnectToProxyWithOptions(rootCtx context.Context, proxyURL string, headers http.Header, auth ConnectAuthorizer, dialer *websocket.Dialer, opts ClientOptions, onConnect func(context.Context, *Session) error) error {
+	logger := loggerOrDefault(opts.Logger).With("url", proxyURL)
+	opts.Logger = logger
+	logger.Info("Connecting to proxy")
 
 	if dialer == nil {
 		dialer = &websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: HandshakeTimeOut}
 	}
-	ws, resp, err := dialer.DialContext(rootCtx, proxyURL, headers)
+	ws, resp, err := dialer.DialContext(rootCtx, proxyURL, withFeatures(headers))
 	if err != nil {
 		if resp == nil {
 			if !errors.Is(err, context.Canceled) {
-				logrus.WithError(err).Errorf("Failed to connect to proxy. Empty dialer response")
+				logger.Error("Failed to connect to proxy. Empty dialer response", "error", err)
 			}
 		} else {
 			rb, err2 := ioutil.ReadAll(resp.Body)
 			if err2 != nil {
-				logrus.WithError(err).Errorf("Failed to connect to proxy. Response status: %v - %v. Couldn't read response body (err: %v)", resp.StatusCode, resp.Status, err2)
+				logger.Error("Failed to connect to proxy. Couldn't read response body", "error", err, "status", resp.Status, "readError", err2)
 			} else {
-				logrus.WithError(err).Errorf("Failed to connect to proxy. Response status: %v - %v. Response body: %s", resp.StatusCode, resp.Status, rb)
+				logger.Error("Failed to connect to proxy", "error", err, "status", resp.Status, "body", string(rb))
 			}
 		}
 		return err
@@ -66,8 +73,14 @@ func ConnectToProxyWithDialer(rootCtx context.Context, proxyURL string, headers
 	defer cancel()
 	ctx = context.WithValue(ctx, ContextKeyCaller, fmt.Sprintf("ConnectToProxy: url: %s", proxyURL))
 
-	session := NewClientSessionWithDialer(auth, ws, localDialer)
+	session := NewClientSessionWithOptions(auth, ws, opts)
+	session.connectExtension = hasFeature(resp.Header, featureConnectExtension)
+	session.serverDial = hasFeature(resp.Header, featureServerDial)
 	defer session.Close()
+	if opts.ServerDialer != nil {
+		opts.ServerDialer.setSession(session)
+		defer opts.ServerDialer.unsetSession(session)
+	}
 
 	if onConnect != nil {
 		go func() {
@@ -82,11 +95,11 @@ func ConnectToProxyWithDialer(rootCtx context.Context, proxyURL string, headers
 		result <- err
 	}()
 
-	logrus.WithField("url", proxyURL).Info("Connected to proxy")
+	logger.Info("Connected to proxy")
 
 	select {
 	case <-ctx.Done():
-		logrus.WithField("url", proxyURL).WithField("err", ctx.Err()).Info("Proxy done")
+		logger.Info("Proxy done", "error", ctx.Err())
 		return nil
 	case err := <-result:
 		return err
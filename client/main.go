@@ -4,21 +4,25 @@ import (
 	"context"
 	"flag"
 	"net/http"
+	"time"
 
 	"github.com/rancher/remotedialer"
+	"github.com/rancher/remotedialer/policy"
 	"github.com/sirupsen/logrus"
 )
 
 var (
-	addr  string
-	id    string
-	debug bool
+	addr       string
+	id         string
+	debug      bool
+	policyFile string
 )
 
 func main() {
 	flag.StringVar(&addr, "connect", "ws://localhost:8123/connect", "Address to connect to")
 	flag.StringVar(&id, "id", "foo", "Client ID")
 	flag.BoolVar(&debug, "debug", true, "Debug logging")
+	flag.StringVar(&policyFile, "policy", "", "YAML or JSON policy file restricting the allowed connections, reloaded on change. Everything is allowed if empty")
 	flag.Parse()
 
 	if debug {
@@ -29,5 +33,22 @@ func main() {
 		"X-Tunnel-ID": []string{id},
 	}
 
-	remotedialer.ClientConnect(context.Background(), addr, headers, nil, func(string, string) bool { return true }, nil)
+	ctx := context.Background()
+	opts := remotedialer.ClientOptions{}
+	auth := func(string, string) bool { return true }
+	if policyFile != "" {
+		p, err := policy.LoadFile(policyFile)
+		if err != nil {
+			logrus.Fatalf("Failed to load policy: %v", err)
+		}
+		engine, err := policy.NewEngine(p, policy.Options{})
+		if err != nil {
+			logrus.Fatalf("Failed to load policy: %v", err)
+		}
+		go engine.Watch(ctx, policyFile, 10*time.Second)
+		opts.DialAuthorizer = engine.DialAuthorizer()
+		opts.LocalDialer = engine.Dialer(nil)
+	}
+
+	remotedialer.ConnectToProxyWithOptions(ctx, addr, headers, auth, nil, opts, nil)
 }
@@ -6,6 +6,8 @@ import (
 	"net"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 func clientDial(ctx context.Context, dialer Dialer, conn *connection, message *message) {
@@ -16,21 +18,49 @@ func clientDial(ctx context.Context, dialer Dialer, conn *connection, message *m
 		err     error
 	)
 
-	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(time.Minute))
-	if dialer == nil {
+	// older peers don't send the dial timeout of their caller
+	timeout := time.Minute
+	if message.dialTimeout > 0 {
+		timeout = message.dialTimeout
+	}
+	start := time.Now()
+	ctx, cancel := context.WithDeadline(ctx, start.Add(timeout))
+	// the dial is canceled as soon as the remote end gives up, closing the connection
+	conn.setDialCancel(cancel)
+	ctx, span := conn.session.otelTracer.Start(ctx, SpanRemoteDial, trace.WithAttributes(connectionAttributes(conn)...))
+	// inMemory is set for the connections served in memory by Endpoints or HTTPPolicies, which get no PROXY header
+	inMemory := true
+	if name, ok := endpointName(message.address); ok && conn.session.endpoints != nil {
+		netConn, err = conn.session.endpoints.dial(ctx, name)
+	} else if policy := conn.session.httpPolicies[canonicalAddress(message.address)]; policy != nil {
+		netConn = policy.dial(ctx, dialer, message.proto, message.address, conn.logger)
+	} else if dialer == nil {
 		d := net.Dialer{}
 		netConn, err = d.DialContext(ctx, message.proto, message.address)
+		inMemory = false
 	} else {
 		netConn, err = dialer(ctx, message.proto, message.address)
+		inMemory = false
 	}
 	cancel()
+	endSpan(span, err)
+	conn.session.metrics.Dial(conn.session.clientKey, message.proto, err == nil, time.Since(start))
 
 	if err != nil {
-		conn.tunnelClose(err)
+		if conn.Err() == nil {
+			conn.tunnelClose(err)
+		}
 		return
 	}
 	defer netConn.Close()
 
+	if version := conn.session.proxyProtocol[message.address]; version != 0 && !inMemory {
+		if err := writeProxyProtocolHeader(netConn, version, message.metadata[DialMetadataSourceAddress], netConn.RemoteAddr()); err != nil {
+			conn.tunnelClose(err)
+			return
+		}
+	}
+
 	pipe(conn, netConn)
 }
 
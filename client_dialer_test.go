@@ -0,0 +1,54 @@
+package remotedialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientDial_CallerDeadlineAndAbort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dials := make(chan context.Context, 1)
+	opts := ClientOptions{
+		// a dial that never completes on its own
+		LocalDialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dials <- ctx
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, nil)
+
+	dialCtx, dialCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer dialCancel()
+	conn, err := server.Dialer("client")(dialCtx, "tcp", "slow:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var remoteCtx context.Context
+	select {
+	case remoteCtx = <-dials:
+	case <-time.After(5 * time.Second):
+		t.Fatal("local dialer was not called")
+	}
+	deadline, ok := remoteCtx.Deadline()
+	if !ok || time.Until(deadline) > 10*time.Second {
+		t.Errorf("expected the caller deadline to apply to the remote dial, got: %v", deadline)
+	}
+
+	// closing the connection stops the remote dial right away
+	conn.Close()
+	select {
+	case <-remoteCtx.Done():
+		if !errors.Is(remoteCtx.Err(), context.Canceled) {
+			t.Errorf("expected the remote dial to be canceled, got: %v", remoteCtx.Err())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("remote dial was not canceled")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/rancher/remotedialer"
+)
+
+var (
+	sessionKey int64
+	connID     int64
+)
+
+// connKey identifies a connection, whose IDs are only unique within a session
+type connKey struct {
+	sessionKey int64
+	connID     int64
+}
+
+func main() {
+	flag.Int64Var(&sessionKey, "session", 0, "Only print frames for this session key")
+	flag.Int64Var(&connID, "conn", 0, "Only print frames for this connection ID")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [capture file]\n\nPrints the per-connection timeline of a remotedialer capture, read from stdin if no file is given.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	in := io.Reader(os.Stdin)
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	timelines := map[connKey][]remotedialer.CapturedFrame{}
+	var dropped int64
+	err := remotedialer.ReadCapture(in, func(frame remotedialer.CapturedFrame) error {
+		if frame.Direction == remotedialer.CaptureDropped {
+			dropped += frame.Dropped
+			return nil
+		}
+		if sessionKey != 0 && frame.SessionKey != sessionKey {
+			return nil
+		}
+		if connID != 0 && frame.ConnID != connID {
+			return nil
+		}
+		key := connKey{sessionKey: frame.SessionKey, connID: frame.ConnID}
+		timelines[key] = append(timelines[key], frame)
+		return nil
+	})
+	// print whatever was decoded, captures are often truncated when a process is killed
+	printTimelines(os.Stdout, timelines)
+	if dropped > 0 {
+		fmt.Fprintf(os.Stderr, "%d frames were dropped while capturing, timelines may be incomplete\n", dropped)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func printTimelines(w io.Writer, timelines map[connKey][]remotedialer.CapturedFrame) {
+	keys := make([]connKey, 0, len(timelines))
+	for key := range timelines {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].sessionKey != keys[j].sessionKey {
+			return keys[i].sessionKey < keys[j].sessionKey
+		}
+		return keys[i].connID < keys[j].connID
+	})
+
+	for _, key := range keys {
+		frames := timelines[key]
+		if key.connID == 0 {
+			fmt.Fprintf(w, "session %d, session frames\n", key.sessionKey)
+		} else {
+			fmt.Fprintf(w, "session %d, connection %d\n", key.sessionKey, key.connID)
+		}
+
+		var in, out int
+		start := frames[0].Time
+		for _, frame := range frames {
+			fmt.Fprintf(w, "  %12s %-5s %s\n", frame.Time.Sub(start).Round(time.Microsecond), frame.Direction, describe(frame))
+			if frame.Type == "DATA" {
+				if frame.Direction == remotedialer.CaptureWrite {
+					out += frame.Size
+				} else {
+					in += frame.Size
+				}
+			}
+		}
+		fmt.Fprintf(w, "  %d frames, %d bytes read, %d bytes written, first frame at %s\n\n", len(frames), in, out, start.Format(time.RFC3339Nano))
+	}
+}
+
+func describe(frame remotedialer.CapturedFrame) string {
+	switch frame.Type {
+	case "CONNECT":
+		return fmt.Sprintf("CONNECT %s/%s", frame.Proto, frame.Address)
+	case "DATA":
+		return fmt.Sprintf("DATA %d bytes", frame.Size)
+	case "ERROR":
+		return fmt.Sprintf("ERROR %q", frame.Error)
+	case "LISTEN":
+		return fmt.Sprintf("LISTEN %s/%s", frame.Proto, frame.Address)
+	case "ADDCLIENT", "REMOVECLIENT", "LISTENREADY", "LISTENACCEPT":
+		return fmt.Sprintf("%s %s", frame.Type, frame.Address)
+	case "SYNCCONNS", "SYNCRANGES":
+		return fmt.Sprintf("%s %d bytes", frame.Type, frame.Size)
+	default:
+		return frame.Type
+	}
+}
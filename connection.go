@@ -4,12 +4,13 @@ import (
 	"context"
 	"errors"
 	"io"
+	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/rancher/remotedialer/metrics"
-	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type connection struct {
@@ -22,20 +23,83 @@ type connection struct {
 	addr          addr
 	session       *Session
 	connID        int64
+	created       time.Time
+	bytesOut      atomic.Int64
+	logger        *slog.Logger
+	// span covers the lifetime of the connection, it's nil until started by the session
+	span trace.Span
+	// outbound, caller and metadata describe who requested the connection, for auditing
+	outbound bool
+	caller   string
+	metadata DialMetadata
+	limiter  *bandwidthLimiter
+	// release frees the slot taken by the connection in the connection limits, if set
+	release func()
+	// lastActivity is the time of the last data sent or received, in Unix nanoseconds
+	lastActivity atomic.Int64
+	idleTimer    *time.Timer
+	// cancelDial cancels the local dial of a connection requested by the remote end, if still in progress
+	cancelDial context.CancelFunc
+	// closeCtx is canceled once the connection is closed, interrupting the waits for bandwidth
+	closeCtx    context.Context
+	cancelClose context.CancelFunc
 }
 
-func newConnection(connID int64, session *Session, proto, address string) *connection {
+// Conn is a connection tunneled through a Session.
+// Connections returned by Session.Dial and Server.Dialer implement this interface.
+type Conn interface {
+	net.Conn
+	// ID returns the connection ID, unique within its session
+	ID() int64
+	// ClientKey returns the client key of the session owning this connection
+	ClientKey() string
+	// SessionKey returns the key of the session owning this connection
+	SessionKey() int64
+	// Created returns the time this connection was created
+	Created() time.Time
+	// Stats returns a snapshot of the connection statistics
+	Stats() ConnStats
+}
+
+// ConnStats holds the statistics of a tunneled connection at a given point in time
+type ConnStats struct {
+	// BytesIn is the number of bytes received from the remote end
+	BytesIn int64
+	// BytesOut is the number of bytes sent to the remote end
+	BytesOut int64
+	// Buffered is the number of bytes received and not yet read
+	Buffered int
+	// Paused is true while back pressure is being applied to this connection
+	Paused bool
+}
+
+// newConnection creates a connection for the given dial context.
+// outbound is true when this end requested the connection, false when it was requested by the remote end.
+func newConnection(ctx context.Context, connID int64, session *Session, proto, address string, outbound bool) *connection {
 	c := &connection{
 		addr: addr{
 			proto:   proto,
 			address: address,
 		},
-		connID:  connID,
-		session: session,
+		connID:   connID,
+		session:  session,
+		created:  time.Now(),
+		logger:   session.logger.With(LogKeyConnID, connID),
+		outbound: outbound,
+		caller:   ValueFromContext(ctx),
+		metadata: DialMetadataFromContext(ctx),
+		limiter:  newBandwidthLimiter(session.connectionRateLimit()),
 	}
+	c.closeCtx, c.cancelClose = context.WithCancel(context.Background())
 	c.backPressure = newBackPressure(c)
-	c.buffer = newReadBuffer(connID, c.backPressure)
-	metrics.IncSMTotalAddConnectionsForWS(session.clientKey, proto, address)
+	c.buffer = newReadBuffer(connID, c.backPressure, c.logger)
+	session.metrics.ConnectionAdded(session.clientKey, proto, address)
+	session.tracer.ConnectionOpened(c.info())
+	c.auditOpened()
+	c.active()
+	if session.idleTimeout > 0 {
+		c.startIdleTimer(session.idleTimeout)
+	}
 	return c
 }
 
@@ -52,39 +116,92 @@ func (c *connection) doTunnelClose(err error) {
 		return
 	}
 
-	metrics.IncSMTotalRemoveConnectionsForWS(c.session.clientKey, c.addr.Network(), c.addr.String())
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	if c.cancelDial != nil {
+		c.cancelDial()
+	}
+	if c.cancelClose != nil {
+		c.cancelClose()
+	}
+	c.session.metrics.ConnectionRemoved(c.session.clientKey, c.addr.Network(), c.addr.String(), time.Since(c.created))
+	endSpan(c.span, err)
 	if err == nil {
 		err = io.ErrClosedPipe
 	}
 
 	c.buffer.Close(err)
 	c.err = err
+	c.session.tracer.ConnectionClosed(c.info(), err)
+	c.auditClosed(err)
+	if c.release != nil {
+		c.release()
+	}
 }
 
 func (c *connection) OnData(r io.Reader) error {
-	if PrintTunnelData {
-		defer func() {
-			logrus.Debugf("ONDATA  [%d] %s", c.connID, c.buffer.Status())
-		}()
+	n, err := c.buffer.Offer(r)
+	if n > 0 {
+		c.active()
 	}
-	return c.buffer.Offer(r)
+	c.buffered(int(n))
+	return err
 }
 
 func (c *connection) Close() error {
 	c.session.closeConnection(c.connID, io.EOF)
 	c.backPressure.Close()
+	// nobody will read what's left in the buffer
+	c.buffered(-c.buffer.discard())
 	return nil
 }
 
+// setDialCancel registers the function canceling the local dial of the connection, calling it right away if already closed
+func (c *connection) setDialCancel(cancel context.CancelFunc) {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+
+	if c.err != nil {
+		cancel()
+		return
+	}
+	c.cancelDial = cancel
+}
+
+// abort closes the connection with err, which is sent to the remote end, dropping any data not read yet
+func (c *connection) abort(err error) {
+	c.session.removeConnection(c.connID)
+	c.tunnelClose(err)
+	c.backPressure.Close()
+	c.buffered(-c.buffer.discard())
+}
+
 func (c *connection) Read(b []byte) (int, error) {
+	chunk := c.rateLimitChunk(directionIn)
+	if chunk > 0 && len(b) > chunk {
+		b = b[:chunk]
+	}
 	n, err := c.buffer.Read(b)
-	metrics.AddSMTotalReceiveBytesOnWS(c.session.clientKey, float64(n))
-	if PrintTunnelData {
-		logrus.Debugf("READ    [%d] %s %d %v", c.connID, c.buffer.Status(), n, err)
+	c.session.metrics.ReceiveBytes(c.session.clientKey, n)
+	c.buffered(-n)
+	if chunk > 0 && n > 0 {
+		// data left in the buffer meanwhile eventually pauses the remote end
+		ctx, cancel := c.readContext()
+		_ = c.waitBandwidth(ctx, directionIn, n)
+		cancel()
 	}
 	return n, err
 }
 
+// readContext returns a context canceled when the read deadline expires or the connection is closed
+func (c *connection) readContext() (context.Context, context.CancelFunc) {
+	if deadline := c.buffer.getDeadline(); !deadline.IsZero() {
+		return context.WithDeadline(c.closeCtx, deadline)
+	}
+	return context.WithCancel(c.closeCtx)
+}
+
 func (c *connection) Write(b []byte) (int, error) {
 	if err := c.Err(); err != nil {
 		if !errors.Is(err, io.ErrClosedPipe) {
@@ -104,26 +221,70 @@ func (c *connection) Write(b []byte) (int, error) {
 		}(ctx)
 	}
 
+	chunk := c.rateLimitChunk(directionOut)
+	if chunk == 0 || len(b) == 0 {
+		return c.write(b, writeDeadline, cancel)
+	}
+	return c.writeChunks(b, chunk, writeDeadline, cancel)
+}
+
+// writeChunks writes b in chunks, waiting for the bandwidth limits before each of them
+func (c *connection) writeChunks(b []byte, chunk int, writeDeadline time.Time, cancel context.CancelFunc) (int, error) {
+	// the write context may be canceled by the back pressure, only the deadline must interrupt the wait for bandwidth
+	ctx := context.Background()
+	if !writeDeadline.IsZero() {
+		var deadlineCancel context.CancelFunc
+		ctx, deadlineCancel = context.WithDeadline(ctx, writeDeadline)
+		defer deadlineCancel()
+	}
+
+	written := 0
+	for written < len(b) {
+		end := min(written+chunk, len(b))
+		if err := c.waitBandwidth(ctx, directionOut, end-written); err != nil {
+			return written, err
+		}
+		n, err := c.write(b[written:end], writeDeadline, cancel)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (c *connection) write(b []byte, writeDeadline time.Time, cancel context.CancelFunc) (int, error) {
 	c.backPressure.Wait(cancel)
 	msg := newMessage(c.connID, b)
-	metrics.AddSMTotalTransmitBytesOnWS(c.session.clientKey, float64(len(msg.Bytes())))
-	return c.session.writeMessage(writeDeadline, msg)
+	c.session.metrics.TransmitBytes(c.session.clientKey, len(msg.Bytes()))
+	n, err := c.session.writeMessage(writeDeadline, msg)
+	if err == nil {
+		c.bytesOut.Add(int64(n))
+	}
+	if n > 0 {
+		c.active()
+	}
+	return n, err
 }
 
 func (c *connection) OnPause() {
+	c.session.tracer.ConnectionPaused(c.info(), false)
 	c.backPressure.OnPause()
 }
 
 func (c *connection) OnResume() {
+	c.session.tracer.ConnectionResumed(c.info(), false)
 	c.backPressure.OnResume()
 }
 
 func (c *connection) Pause() {
+	c.session.tracer.ConnectionPaused(c.info(), true)
 	msg := newPause(c.connID)
 	_, _ = c.session.writeMessage(c.writeDeadline, msg)
 }
 
 func (c *connection) Resume() {
+	c.session.tracer.ConnectionResumed(c.info(), true)
 	msg := newResume(c.connID)
 	_, _ = c.session.writeMessage(c.writeDeadline, msg)
 }
@@ -131,14 +292,40 @@ func (c *connection) Resume() {
 func (c *connection) writeErr(err error) {
 	if err != nil {
 		msg := newErrorMessage(c.connID, err)
-		metrics.AddSMTotalTransmitErrorBytesOnWS(c.session.clientKey, float64(len(msg.Bytes())))
+		c.session.metrics.TransmitErrorBytes(c.session.clientKey, len(msg.Bytes()))
 		deadline := time.Now().Add(SendErrorTimeout)
 		if _, err2 := c.session.writeMessage(deadline, msg); err2 != nil {
-			logrus.Warnf("[%d] encountered error %q while writing error %q to close remotedialer", c.connID, err2, err)
+			c.logger.Warn("encountered error while writing error to close remotedialer", "writeError", err2, "error", err)
 		}
 	}
 }
 
+func (c *connection) ID() int64 {
+	return c.connID
+}
+
+func (c *connection) ClientKey() string {
+	return c.session.clientKey
+}
+
+func (c *connection) SessionKey() int64 {
+	return c.session.sessionKey
+}
+
+func (c *connection) Created() time.Time {
+	return c.created
+}
+
+func (c *connection) Stats() ConnStats {
+	bytesIn, buffered := c.buffer.stats()
+	return ConnStats{
+		BytesIn:  bytesIn,
+		BytesOut: c.bytesOut.Load(),
+		Buffered: buffered,
+		Paused:   c.backPressure.isPaused(),
+	}
+}
+
 func (c *connection) LocalAddr() net.Addr {
 	return c.addr
 }
@@ -155,7 +342,7 @@ func (c *connection) SetDeadline(t time.Time) error {
 }
 
 func (c *connection) SetReadDeadline(t time.Time) error {
-	c.buffer.deadline = t
+	c.buffer.setDeadline(t)
 	return nil
 }
 
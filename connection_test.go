@@ -1,6 +1,9 @@
 package remotedialer
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -15,7 +18,7 @@ func TestConnectionSetWriteDeadlineConcurrentWrite(t *testing.T) {
 			return nil
 		},
 	}
-	conn := newConnection(getDummyConnectionID(), s, "test", "test")
+	conn := newConnection(context.Background(), getDummyConnectionID(), s, "test", "test", false)
 
 	const iterations = 1000
 	start := make(chan struct{})
@@ -47,3 +50,63 @@ func TestConnectionSetWriteDeadlineConcurrentWrite(t *testing.T) {
 	close(start)
 	wg.Wait()
 }
+
+func TestConnectionStats(t *testing.T) {
+	t.Parallel()
+
+	s := setupDummySession(t, 0)
+	s.conn = &fakeWSConn{
+		writeMessageCallback: func(int, time.Time, []byte) error {
+			return nil
+		},
+	}
+	connID := getDummyConnectionID()
+	conn := newConnection(context.Background(), connID, s, "test", "test", false)
+	s.addConnection(connID, conn)
+
+	if _, err := conn.Write([]byte("outgoing")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.OnData(strings.NewReader("incoming data")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := ConnStats{
+		BytesIn:  int64(len("incoming data")),
+		BytesOut: int64(len("outgoing")),
+		Buffered: len("incoming data") - 5,
+	}
+	if got := conn.Stats(); got != want {
+		t.Errorf("incorrect stats, got: %+v, want: %+v", got, want)
+	}
+
+	conns := s.Connections()
+	if len(conns) != 1 || conns[0].ID() != connID {
+		t.Fatalf("incorrect session connections, got: %v", conns)
+	}
+	if got, want := conns[0].RemoteAddr().String(), "test"; got != want {
+		t.Errorf("incorrect address, got: %q, want: %q", got, want)
+	}
+}
+
+func TestConnectionStatsWriteError(t *testing.T) {
+	t.Parallel()
+
+	s := setupDummySession(t, 0)
+	s.conn = &fakeWSConn{
+		writeMessageCallback: func(int, time.Time, []byte) error {
+			return errors.New("write failed")
+		},
+	}
+	conn := newConnection(context.Background(), getDummyConnectionID(), s, "test", "test", false)
+
+	if _, err := conn.Write([]byte("outgoing")); err == nil {
+		t.Fatal("expected write error")
+	}
+	if got := conn.Stats().BytesOut; got != 0 {
+		t.Errorf("incorrect bytes out, got: %d, want: 0", got)
+	}
+}
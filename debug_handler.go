@@ -0,0 +1,247 @@
+package remotedialer
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var errClosedByAdmin = errors.New("closed by administrator")
+
+// DebugHandler returns an http.Handler exposing the live state of the Server's tunnels.
+//
+//	GET    /                                       sessions, peers and connections as JSON, or HTML when requested by a browser or with ?format=html
+//	DELETE /sessions/{sessionKey}                  disconnects a session
+//	DELETE /sessions/{sessionKey}/connections/{id} closes a single connection
+//	GET    /captures/{clientKey}?duration=30s      streams a capture of the client's frames, see ReadCapture
+//
+// The handler performs no authorization of its own, so it must never be exposed without protection.
+// Use http.StripPrefix to mount it under a path other than the root.
+func (s *Server) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.serveDebugState)
+	mux.HandleFunc("DELETE /sessions/{sessionKey}", s.serveDebugCloseSession)
+	mux.HandleFunc("DELETE /sessions/{sessionKey}/connections/{connID}", s.serveDebugCloseConnection)
+	mux.HandleFunc("GET /captures/{clientKey}", s.serveDebugCapture)
+	return mux
+}
+
+type debugState struct {
+	Sessions []debugSession `json:"sessions"`
+	Peers    []debugPeer    `json:"peers"`
+}
+
+type debugSession struct {
+	ClientKey     string            `json:"clientKey"`
+	SessionKey    int64             `json:"sessionKey"`
+	Peer          bool              `json:"peer"`
+	Cordoned      bool              `json:"cordoned"`
+	RemoteClients map[string][]int  `json:"remoteClients,omitempty"`
+	Connections   []debugConnection `json:"connections"`
+}
+
+type debugConnection struct {
+	ID       int64     `json:"id"`
+	Proto    string    `json:"proto"`
+	Address  string    `json:"address"`
+	Created  time.Time `json:"created"`
+	BytesIn  int64     `json:"bytesIn"`
+	BytesOut int64     `json:"bytesOut"`
+	Buffered int       `json:"buffered"`
+	Paused   bool      `json:"paused"`
+}
+
+type debugPeer struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+func (s *Server) debugState() debugState {
+	state := debugState{
+		Sessions: []debugSession{},
+		Peers:    []debugPeer{},
+	}
+
+	clients, peers := s.sessions.listSessions()
+	for _, store := range []struct {
+		sessions []*Session
+		peer     bool
+	}{{clients, false}, {peers, true}} {
+		for _, session := range store.sessions {
+			ds := debugSession{
+				ClientKey:   session.clientKey,
+				SessionKey:  session.sessionKey,
+				Peer:        store.peer,
+				Cordoned:    s.sessions.isCordoned(session.clientKey),
+				Connections: []debugConnection{},
+			}
+			if store.peer {
+				ds.RemoteClients = session.listRemoteClients()
+			}
+			for _, conn := range session.Connections() {
+				stats := conn.Stats()
+				ds.Connections = append(ds.Connections, debugConnection{
+					ID:       conn.ID(),
+					Proto:    conn.RemoteAddr().Network(),
+					Address:  conn.RemoteAddr().String(),
+					Created:  conn.Created(),
+					BytesIn:  stats.BytesIn,
+					BytesOut: stats.BytesOut,
+					Buffered: stats.Buffered,
+					Paused:   stats.Paused,
+				})
+			}
+			state.Sessions = append(state.Sessions, ds)
+		}
+	}
+	sort.Slice(state.Sessions, func(i, j int) bool {
+		if state.Sessions[i].ClientKey != state.Sessions[j].ClientKey {
+			return state.Sessions[i].ClientKey < state.Sessions[j].ClientKey
+		}
+		return state.Sessions[i].SessionKey < state.Sessions[j].SessionKey
+	})
+
+	s.peerLock.Lock()
+	for _, p := range s.peers {
+		state.Peers = append(state.Peers, debugPeer{ID: p.id, URL: p.url})
+	}
+	s.peerLock.Unlock()
+	sort.Slice(state.Peers, func(i, j int) bool { return state.Peers[i].ID < state.Peers[j].ID })
+
+	return state
+}
+
+func (s *Server) serveDebugState(rw http.ResponseWriter, req *http.Request) {
+	state := s.debugState()
+
+	if req.URL.Query().Get("format") == "html" || (req.URL.Query().Get("format") == "" && strings.Contains(req.Header.Get("Accept"), "text/html")) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = debugTemplate.Execute(rw, state)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(state)
+}
+
+func (s *Server) serveDebugCloseSession(rw http.ResponseWriter, req *http.Request) {
+	session, ok := s.debugSessionFromRequest(rw, req)
+	if !ok {
+		return
+	}
+
+	session.disconnect(websocket.CloseNormalClosure, errClosedByAdmin.Error())
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) serveDebugCloseConnection(rw http.ResponseWriter, req *http.Request) {
+	session, ok := s.debugSessionFromRequest(rw, req)
+	if !ok {
+		return
+	}
+
+	connID, err := strconv.ParseInt(req.PathValue("connID"), 10, 64)
+	if err != nil {
+		http.Error(rw, "invalid connection ID", http.StatusBadRequest)
+		return
+	}
+	if session.getConnection(connID) == nil {
+		http.Error(rw, "connection not found", http.StatusNotFound)
+		return
+	}
+
+	session.closeConnection(connID, errClosedByAdmin)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) serveDebugCapture(rw http.ResponseWriter, req *http.Request) {
+	duration := 30 * time.Second
+	if d := req.URL.Query().Get("duration"); d != "" {
+		var err error
+		if duration, err = time.ParseDuration(d); err != nil || duration <= 0 {
+			http.Error(rw, "invalid duration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Disposition", `attachment; filename="remotedialer.rdcap"`)
+	if err := s.StartCapture(req.PathValue("clientKey"), flushWriter{rw}); err != nil {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
+	defer s.StopCapture(req.PathValue("clientKey"))
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-req.Context().Done():
+	}
+}
+
+// flushWriter flushes every write, so captured frames are sent to the client as they happen
+type flushWriter struct {
+	rw http.ResponseWriter
+}
+
+func (w flushWriter) Write(p []byte) (int, error) {
+	n, err := w.rw.Write(p)
+	if flusher, ok := w.rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+func (s *Server) debugSessionFromRequest(rw http.ResponseWriter, req *http.Request) (*Session, bool) {
+	sessionKey, err := strconv.ParseInt(req.PathValue("sessionKey"), 10, 64)
+	if err != nil {
+		http.Error(rw, "invalid session key", http.StatusBadRequest)
+		return nil, false
+	}
+
+	session := s.sessions.getSessionByKey(sessionKey)
+	if session == nil {
+		http.Error(rw, "session not found", http.StatusNotFound)
+		return nil, false
+	}
+	return session, true
+}
+
+var debugTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html>
+<head><title>remotedialer</title></head>
+<body>
+<h1>Sessions</h1>
+{{range .Sessions}}
+<h2>{{.ClientKey}} / {{.SessionKey}}{{if .Peer}} (peer){{end}}{{if .Cordoned}} (cordoned){{end}}</h2>
+{{if .RemoteClients}}
+<p>Remote clients:{{range $clientKey, $sessionKeys := .RemoteClients}} {{$clientKey}} {{$sessionKeys}}{{end}}</p>
+{{end}}
+<table border="1">
+<tr><th>ID</th><th>Proto</th><th>Address</th><th>Created</th><th>Bytes in</th><th>Bytes out</th><th>Buffered</th><th>Paused</th></tr>
+{{range .Connections}}
+<tr><td>{{.ID}}</td><td>{{.Proto}}</td><td>{{.Address}}</td><td>{{.Created}}</td><td>{{.BytesIn}}</td><td>{{.BytesOut}}</td><td>{{.Buffered}}</td><td>{{.Paused}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p>No sessions</p>
+{{end}}
+<h1>Peers</h1>
+<ul>
+{{range .Peers}}
+<li>{{.ID}}: {{.URL}}</li>
+{{else}}
+<li>No peers</li>
+{{end}}
+</ul>
+</body>
+</html>
+`))
@@ -0,0 +1,81 @@
+package remotedialer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_DebugHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverAddress, server, err := newTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newTestClient(ctx, "ws://"+serverAddress); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.WaitForSession(ctx, "client"); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conn, err := server.Dialer("client")(ctx, "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	handler := server.DebugHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("incorrect status code, got: %d, want: %d", got, want)
+	}
+
+	var state debugState
+	if err := json.NewDecoder(rec.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Sessions) != 1 || len(state.Sessions[0].Connections) != 1 {
+		t.Fatalf("unexpected state: %+v", state)
+	}
+	session, debugConn := state.Sessions[0], state.Sessions[0].Connections[0]
+	if got, want := debugConn.Address, listener.Addr().String(); got != want {
+		t.Errorf("incorrect connection address, got: %q, want: %q", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?format=html", nil))
+	if !strings.Contains(rec.Body.String(), listener.Addr().String()) {
+		t.Errorf("HTML output does not contain the connection address: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/sessions/%d/connections/%d", session.SessionKey, debugConn.ID), nil))
+	if got, want := rec.Code, http.StatusNoContent; got != want {
+		t.Fatalf("incorrect status code closing connection, got: %d, want: %d", got, want)
+	}
+	if got := server.Connections("client"); len(got) != 0 {
+		t.Errorf("connection was not closed, got: %v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/sessions/1/connections/1", nil))
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("incorrect status code for unknown session, got: %d, want: %d", got, want)
+	}
+}
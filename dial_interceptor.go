@@ -0,0 +1,93 @@
+package remotedialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// ErrDialForbidden is returned by dials rejected by the function passed to AuthorizeDials
+var ErrDialForbidden = errors.New("dial forbidden")
+
+// DialInfo describes a dial through a Server
+type DialInfo struct {
+	ClientKey string
+	Network   string
+	Address   string
+	// Caller identifies the code requesting the dial, as set in the context with ContextKeyCaller
+	Caller string
+	// Metadata is the DialMetadata attached to the dial context
+	Metadata DialMetadata
+	// PeerID is set when the dial was requested by a peer Server on behalf of one of its callers
+	PeerID string
+}
+
+// DialHandler dials the connection described by info
+type DialHandler func(ctx context.Context, info DialInfo) (net.Conn, error)
+
+// DialInterceptor wraps a DialHandler, to authorize, annotate, limit or audit the dials performed through a Server.
+// Interceptors can modify the context and DialInfo passed to next, or return without calling it to reject the dial.
+type DialInterceptor func(next DialHandler) DialHandler
+
+// ChainDialInterceptors composes interceptors into a single one, the first one being the outermost
+func ChainDialInterceptors(interceptors ...DialInterceptor) DialInterceptor {
+	return func(next DialHandler) DialHandler {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			next = interceptors[i](next)
+		}
+		return next
+	}
+}
+
+// AuthorizeDials returns a DialInterceptor rejecting the dials for which authorize returns an error.
+// The error returned to the caller wraps both ErrDialForbidden and the error returned by authorize.
+func AuthorizeDials(authorize func(ctx context.Context, info DialInfo) error) DialInterceptor {
+	return func(next DialHandler) DialHandler {
+		return func(ctx context.Context, info DialInfo) (net.Conn, error) {
+			if err := authorize(ctx, info); err != nil {
+				return nil, fmt.Errorf("%w to %s/%s for client %s: %w", ErrDialForbidden, info.Network, info.Address, info.ClientKey, err)
+			}
+			return next(ctx, info)
+		}
+	}
+}
+
+// LogDials returns a DialInterceptor logging every dial with its outcome. The global logrus logger is used if nil.
+func LogDials(logger *slog.Logger) DialInterceptor {
+	logger = loggerOrDefault(logger)
+	return func(next DialHandler) DialHandler {
+		return func(ctx context.Context, info DialInfo) (net.Conn, error) {
+			start := time.Now()
+			conn, err := next(ctx, info)
+			attrs := []any{
+				LogKeyClientKey, info.ClientKey,
+				"network", info.Network,
+				"address", info.Address,
+				"caller", info.Caller,
+				"duration", time.Since(start),
+			}
+			if info.PeerID != "" {
+				attrs = append(attrs, LogKeyPeer, info.PeerID)
+			}
+			if len(info.Metadata) > 0 {
+				attrs = append(attrs, "metadata", info.Metadata)
+			}
+			if err != nil {
+				logger.Info("Dial failed", append(attrs, "error", err)...)
+			} else {
+				logger.Info("Dial succeeded", attrs...)
+			}
+			return conn, err
+		}
+	}
+}
+
+// dial performs a dial through the configured DialInterceptors
+func (s *Server) dial(ctx context.Context, info DialInfo) (net.Conn, error) {
+	info.Caller = ValueFromContext(ctx)
+	info.Metadata = DialMetadataFromContext(ctx)
+	return ChainDialInterceptors(s.DialInterceptors...)(s.dialSession)(ctx, info)
+}
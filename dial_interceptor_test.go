@@ -0,0 +1,80 @@
+package remotedialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServer_DialInterceptors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var order []string
+	record := func(name string) DialInterceptor {
+		return func(next DialHandler) DialHandler {
+			return func(ctx context.Context, info DialInfo) (net.Conn, error) {
+				order = append(order, name)
+				return next(ctx, info)
+			}
+		}
+	}
+	annotate := func(next DialHandler) DialHandler {
+		return func(ctx context.Context, info DialInfo) (net.Conn, error) {
+			return next(WithDialMetadata(ctx, DialMetadata{"caller": info.Caller}), info)
+		}
+	}
+
+	dialed := make(chan DialMetadata, 1)
+	opts := ClientOptions{
+		LocalDialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed <- DialMetadataFromContext(ctx)
+			client, server := net.Pipe()
+			go server.Close()
+			return client, nil
+		},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, func(server *Server) {
+		server.DialInterceptors = []DialInterceptor{
+			record("first"),
+			AuthorizeDials(func(ctx context.Context, info DialInfo) error {
+				if _, port, _ := net.SplitHostPort(info.Address); port == "10250" && info.Caller != "health-checker" {
+					return errors.New("only the health checker may dial the kubelet")
+				}
+				return nil
+			}),
+			record("second"),
+			annotate,
+			LogDials(nil),
+		}
+	})
+
+	if _, err := server.Dialer("client")(ctx, "tcp", "node:10250"); !errors.Is(err, ErrDialForbidden) {
+		t.Errorf("unexpected error, got: %v, want: %v", err, ErrDialForbidden)
+	}
+	if got := len(order); got != 1 || order[0] != "first" {
+		t.Errorf("rejected dial should stop the chain, got: %v", order)
+	}
+
+	order = nil
+	callerCtx := context.WithValue(ctx, ContextKeyCaller, "health-checker")
+	conn, err := server.Dialer("client")(callerCtx, "tcp", "node:10250")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := len(order); got != 2 || order[0] != "first" || order[1] != "second" {
+		t.Errorf("unexpected interceptor order: %v", order)
+	}
+
+	select {
+	case metadata := <-dialed:
+		if metadata["caller"] != "health-checker" {
+			t.Errorf("annotated metadata not received by the client, got: %v", metadata)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("local dialer was not called")
+	}
+}
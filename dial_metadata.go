@@ -0,0 +1,113 @@
+package remotedialer
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"strings"
+)
+
+const (
+	// featuresHeader lists the optional protocol features supported by each end, sent in the handshake request and response
+	featuresHeader = "X-API-Tunnel-Features"
+	// featureConnectExtension is the support of the Connect message extension, carrying trace context and dial metadata
+	featureConnectExtension = "connect-extension"
+)
+
+// supportedFeatures lists the optional protocol features announced by this end
+var supportedFeatures = strings.Join([]string{featureConnectExtension, featureServerDial}, ", ")
+
+// withFeatures returns a copy of headers announcing the protocol features supported by this end
+func withFeatures(headers http.Header) http.Header {
+	headers = headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	headers.Set(featuresHeader, supportedFeatures)
+	return headers
+}
+
+// hasFeature reports whether the remote end announced the given feature in the handshake headers
+func hasFeature(headers http.Header, feature string) bool {
+	for _, value := range headers.Values(featuresHeader) {
+		for _, f := range strings.Split(value, ",") {
+			if strings.TrimSpace(f) == feature {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DialMetadata is a set of key/value pairs sent with a dial to the remote end, such as the requesting user, purpose or tenant.
+// The remote end receives it in its DialAuthorizer and in the context of its local Dialer.
+//
+// Sessions older than this feature can't parse Connect messages carrying metadata, so it's only sent to the remote ends
+// announcing their support during the handshake, and dropped otherwise.
+type DialMetadata map[string]string
+
+// DialMetadataCaller is the DialMetadata key holding the caller of a dial, set in its context with ContextKeyCaller.
+// It's added to the metadata sent to the remote end, unless already set, so both ends record the same caller.
+const DialMetadataCaller = "caller"
+
+type dialMetadataKey struct{}
+
+// WithDialMetadata returns a copy of ctx carrying metadata, sent with any dial performed using the returned context.
+// Metadata already present in ctx is kept, unless overridden by a key in metadata.
+func WithDialMetadata(ctx context.Context, metadata DialMetadata) context.Context {
+	if len(metadata) == 0 {
+		return ctx
+	}
+	merged := maps.Clone(DialMetadataFromContext(ctx))
+	if merged == nil {
+		merged = DialMetadata{}
+	}
+	maps.Copy(merged, metadata)
+	return context.WithValue(ctx, dialMetadataKey{}, merged)
+}
+
+// DialMetadataFromContext returns the metadata attached to ctx, either by WithDialMetadata on the dialing side
+// or by the Session on the remote end, for the context passed to the local Dialer. The returned map must not be modified.
+func DialMetadataFromContext(ctx context.Context) DialMetadata {
+	metadata, _ := ctx.Value(dialMetadataKey{}).(DialMetadata)
+	return metadata
+}
+
+// connectMetadata returns the metadata sent with a dial, including its caller
+func connectMetadata(ctx context.Context) DialMetadata {
+	metadata := DialMetadataFromContext(ctx)
+	caller := ValueFromContext(ctx)
+	if caller == "" || metadata[DialMetadataCaller] != "" {
+		return metadata
+	}
+	metadata = maps.Clone(metadata)
+	if metadata == nil {
+		metadata = DialMetadata{}
+	}
+	metadata[DialMetadataCaller] = caller
+	return metadata
+}
+
+// DialRequest describes a connection requested by the remote end
+type DialRequest struct {
+	Proto   string
+	Address string
+	// Metadata is the DialMetadata attached by the dialing side, nil if none was sent
+	Metadata DialMetadata
+}
+
+// DialAuthorizer decides whether a connection requested by the remote end is allowed.
+// Unlike ConnectAuthorizer, it has access to the metadata of the dial.
+type DialAuthorizer func(req DialRequest) bool
+
+// authorize reports whether the connection requested by a Connect message is allowed
+func (s *Session) authorize(message *message) bool {
+	if s.dialAuth != nil {
+		return s.dialAuth(DialRequest{
+			Proto:    message.proto,
+			Address:  message.address,
+			Metadata: message.metadata,
+		})
+	}
+	return s.auth != nil && s.auth(message.proto, message.address)
+}
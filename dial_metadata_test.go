@@ -0,0 +1,132 @@
+package remotedialer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithDialMetadata(t *testing.T) {
+	ctx := WithDialMetadata(context.Background(), DialMetadata{"user": "alice", "tenant": "a"})
+	ctx = WithDialMetadata(ctx, DialMetadata{"tenant": "b"})
+
+	got := DialMetadataFromContext(ctx)
+	if got["user"] != "alice" || got["tenant"] != "b" {
+		t.Errorf("unexpected metadata: %v", got)
+	}
+	if DialMetadataFromContext(context.Background()) != nil {
+		t.Error("expected no metadata in an empty context")
+	}
+}
+
+func TestDialMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requests := make(chan DialRequest, 1)
+	dialed := make(chan DialMetadata, 1)
+	opts := ClientOptions{
+		DialAuthorizer: func(req DialRequest) bool {
+			requests <- req
+			return req.Metadata["user"] == "alice"
+		},
+		LocalDialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed <- DialMetadataFromContext(ctx)
+			client, server := net.Pipe()
+			go server.Close()
+			return client, nil
+		},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, nil)
+
+	dialCtx := WithDialMetadata(ctx, DialMetadata{"user": "alice", "purpose": "logs"})
+	conn, err := server.Dialer("client")(dialCtx, "tcp", "downstream:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case req := <-requests:
+		if req.Proto != "tcp" || req.Address != "downstream:443" || req.Metadata["purpose"] != "logs" {
+			t.Errorf("unexpected dial request: %+v", req)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dial authorizer was not called")
+	}
+
+	select {
+	case metadata := <-dialed:
+		if metadata["user"] != "alice" || metadata["purpose"] != "logs" {
+			t.Errorf("unexpected metadata in the local dialer context: %v", metadata)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("local dialer was not called")
+	}
+}
+
+// legacyConnectAddress parses a Connect frame the way peers older than the Connect extension do
+func legacyConnectAddress(t *testing.T, frame []byte) string {
+	t.Helper()
+
+	r := bufio.NewReader(bytes.NewReader(frame))
+	for range 4 {
+		// message ID, connection ID, message type and deadline
+		if _, err := binary.ReadVarint(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	payload, err := io.ReadAll(io.LimitReader(r, 266))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.SplitN(string(payload), "/", 2)
+	if len(parts) != 2 {
+		t.Fatalf("failed to parse connect address: %q", payload)
+	}
+	return parts[1]
+}
+
+func TestDialMetadataNotSentToLegacyPeers(t *testing.T) {
+	frames := make(chan []byte, 1)
+	s := setupDummySession(t, 0)
+	s.conn = &fakeWSConn{
+		writeMessageCallback: func(_ int, _ time.Time, data []byte) error {
+			if msg, err := newServerMessage(bytes.NewReader(data)); err == nil && msg.messageType == Connect {
+				frames <- data
+			}
+			return nil
+		},
+	}
+
+	ctx := WithDialMetadata(context.Background(), DialMetadata{"user": "alice"})
+	conn, err := s.Dial(ctx, "tcp", "downstream:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := legacyConnectAddress(t, <-frames); got != "downstream:443" {
+		t.Errorf("expected a legacy peer to get the plain address, got: %q", got)
+	}
+
+	// once the remote end announced its support, the metadata is sent
+	s.connectExtension = true
+	conn, err = s.Dial(ctx, "tcp", "downstream:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	msg, err := newServerMessage(bytes.NewReader(<-frames))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.address != "downstream:443" || msg.metadata["user"] != "alice" {
+		t.Errorf("expected the metadata to be sent, got: %s with %v", msg.address, msg.metadata)
+	}
+}
@@ -8,17 +8,47 @@ import (
 type Dialer func(ctx context.Context, network, address string) (net.Conn, error)
 
 func (s *Server) HasSession(clientKey string) bool {
-	_, err := s.sessions.getDialer(clientKey)
-	return err == nil
+	return s.sessions.hasSession(clientKey)
 }
 
+// WaitForSession blocks until a session for the given client key is available, either directly connected or through a peer.
+// It returns an error if ctx is done before that happens.
+func (s *Server) WaitForSession(ctx context.Context, clientKey string) error {
+	_, err := s.sessions.waitForDialer(ctx, clientKey)
+	return err
+}
+
+// Connections returns the active connections for every session of the given client key.
+// Connections reaching the client through a peer are listed by the peer, not here.
+func (s *Server) Connections(clientKey string) []Conn {
+	var res []Conn
+	for _, session := range s.sessions.getSessions(clientKey) {
+		res = append(res, session.Connections()...)
+	}
+	return res
+}
+
+// Dialer returns a Dialer for the given client key. Every dial goes through the Server's DialInterceptors.
 func (s *Server) Dialer(clientKey string) Dialer {
 	return func(ctx context.Context, network, address string) (net.Conn, error) {
-		d, err := s.sessions.getDialer(clientKey)
-		if err != nil {
-			return nil, err
-		}
+		return s.dial(ctx, DialInfo{ClientKey: clientKey, Network: network, Address: address})
+	}
+}
 
-		return d(ctx, network, address)
+// dialSession dials through a session of the client, either directly connected or through a peer
+func (s *Server) dialSession(ctx context.Context, info DialInfo) (net.Conn, error) {
+	var (
+		d   Dialer
+		err error
+	)
+	if s.WaitForSessionOnDial {
+		d, err = s.sessions.waitForDialer(ctx, info.ClientKey)
+	} else {
+		d, err = s.sessions.getDialer(info.ClientKey)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	return d(ctx, info.Network, info.Address)
 }
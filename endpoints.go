@@ -0,0 +1,139 @@
+package remotedialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrEndpointNotFound is returned by dials to a virtual address without a registered endpoint
+var ErrEndpointNotFound = errors.New("endpoint not found")
+
+// endpointScheme prefixes the virtual addresses of Endpoints
+const endpointScheme = "local://"
+
+// Endpoints serves the connections requested by the remote host to virtual addresses such as local://name in memory,
+// without opening a local port. Connect requests to those addresses are still subject to the ConnectAuthorizer of the session.
+type Endpoints struct {
+	lock      sync.Mutex
+	listeners map[string]*endpointListener
+}
+
+// NewEndpoints creates an empty set of Endpoints, to be set in ClientOptions
+func NewEndpoints() *Endpoints {
+	return &Endpoints{
+		listeners: map[string]*endpointListener{},
+	}
+}
+
+// Listen registers the endpoint local://name, returning a net.Listener accepting its connections.
+// Closing the listener unregisters the endpoint.
+func (e *Endpoints) Listen(name string) (net.Listener, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if _, ok := e.listeners[name]; ok {
+		return nil, fmt.Errorf("endpoint %s%s already registered", endpointScheme, name)
+	}
+	l := &endpointListener{
+		endpoints: e,
+		name:      name,
+		conns:     make(chan net.Conn),
+		done:      make(chan struct{}),
+	}
+	e.listeners[name] = l
+	return l, nil
+}
+
+// Handle registers the endpoint local://name, serving its connections with handler until removed
+func (e *Endpoints) Handle(name string, handler http.Handler) error {
+	l, err := e.Listen(name)
+	if err != nil {
+		return err
+	}
+	go func() {
+		_ = (&http.Server{Handler: handler}).Serve(l)
+	}()
+	return nil
+}
+
+// Remove unregisters the endpoint local://name, closing its listener
+func (e *Endpoints) Remove(name string) {
+	e.lock.Lock()
+	l := e.listeners[name]
+	e.lock.Unlock()
+
+	if l != nil {
+		_ = l.Close()
+	}
+}
+
+// dial connects to the endpoint local://name, waiting for it to accept the connection until ctx is done
+func (e *Endpoints) dial(ctx context.Context, name string) (net.Conn, error) {
+	e.lock.Lock()
+	l := e.listeners[name]
+	e.lock.Unlock()
+	if l == nil {
+		return nil, ErrEndpointNotFound
+	}
+
+	client, server := net.Pipe()
+	var err error
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		err = ErrEndpointNotFound
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	client.Close()
+	server.Close()
+	return nil, err
+}
+
+// endpointName returns the name of the endpoint for virtual addresses
+func endpointName(address string) (string, bool) {
+	return strings.CutPrefix(address, endpointScheme)
+}
+
+// endpointListener is the net.Listener of an endpoint
+type endpointListener struct {
+	endpoints *Endpoints
+	name      string
+	conns     chan net.Conn
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Accept waits for the next connection to the endpoint
+func (l *endpointListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close unregisters the endpoint. Connections already accepted are not closed.
+func (l *endpointListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.endpoints.lock.Lock()
+		if l.endpoints.listeners[l.name] == l {
+			delete(l.endpoints.listeners, l.name)
+		}
+		l.endpoints.lock.Unlock()
+		close(l.done)
+	})
+	return nil
+}
+
+// Addr returns the virtual address of the endpoint
+func (l *endpointListener) Addr() net.Addr {
+	return addr{proto: "local", address: endpointScheme + l.name}
+}
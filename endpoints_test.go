@@ -0,0 +1,65 @@
+package remotedialer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEndpoints(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	endpoints := NewEndpoints()
+	if err := endpoints.Handle("steve", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("hello from steve"))
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if err := endpoints.Handle("steve", http.NotFoundHandler()); err == nil {
+		t.Error("expected registering the same endpoint twice to fail")
+	}
+
+	opts := ClientOptions{
+		Endpoints: endpoints,
+		LocalDialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			t.Errorf("unexpected dial to %s/%s, virtual addresses should be served in memory", network, address)
+			return nil, errors.New("unexpected dial")
+		},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, nil)
+
+	dialer := server.Dialer("client")
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer(ctx, "tcp", "local://steve")
+			},
+		},
+	}
+	resp, err := client.Get("http://steve/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if string(body) != "hello from steve" {
+		t.Errorf("unexpected response, got: %q", body)
+	}
+
+	endpoints.Remove("steve")
+	conn, err := dialer(ctx, "tcp", "local://steve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, ErrEndpointNotFound) {
+		t.Errorf("expected the connection to a removed endpoint to fail with ErrEndpointNotFound, got: %v", err)
+	}
+}
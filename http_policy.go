@@ -0,0 +1,329 @@
+package remotedialer
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// HTTPPolicy serves the connections to an address in HTTP mode: the requests sent through the tunnel are parsed
+// and checked against its rules, then forwarded to the address with the configured credentials.
+// Denied requests get a 403 response whose body is a Kubernetes Status, so Kubernetes clients report it properly.
+//
+// The remote end speaks plain HTTP through the tunnel, the connection to the address uses TLS if TLSConfig is set.
+type HTTPPolicy struct {
+	// Rules are evaluated in order, the first rule matching a request decides whether it's allowed
+	Rules []HTTPRule
+	// DefaultAllow allows the requests matching no rule, which are denied otherwise
+	DefaultAllow bool
+	// Header is set on every allowed request, replacing the values sent by the remote end, such as credentials
+	Header http.Header
+	// BearerTokenFile is read for every allowed request, sending its content as a bearer token.
+	// Tokens rotated on disk, such as Kubernetes service account tokens, are picked up right away.
+	BearerTokenFile string
+	// StripHeaders lists the headers sent by the remote end that are removed when credentials are injected with Header
+	// or BearerTokenFile, so they can't be combined with them. A name ending with "*" removes every header starting
+	// with the rest of the name. DefaultHTTPStripHeaders is used if nil, an empty list keeps every header.
+	StripHeaders []string
+	// TLSConfig, if set, is used to connect to the address over TLS
+	TLSConfig *tls.Config
+}
+
+// DefaultHTTPStripHeaders are the headers removed by default from the requests whose credentials are injected,
+// carrying the credentials of the remote end or acting on behalf of another user
+var DefaultHTTPStripHeaders = []string{"Authorization", "Cookie", "Impersonate-*"}
+
+// HTTPRule matches a request when every one of its non-empty criteria matches
+type HTTPRule struct {
+	// Name identifies the rule in the logs and denied responses
+	Name  string
+	Allow bool
+	// Methods lists the methods matched, case-insensitive
+	Methods []string
+	// Paths lists the URL paths matched, as patterns using the path.Match syntax.
+	// A pattern ending with "/**" also matches any path below it.
+	Paths []string
+	// Headers maps the name of the headers required to a pattern their value must match, using the path.Match syntax.
+	// When a header is repeated, an allowing rule requires every value to match while a denying rule requires any.
+	Headers map[string]string
+}
+
+// httpDecision is the result of evaluating a request against an HTTPPolicy
+type httpDecision struct {
+	allowed bool
+	// rule is the name of the matching rule, or its position if unnamed. It's empty when the default was used.
+	rule string
+}
+
+func (p *HTTPPolicy) evaluate(req *http.Request) httpDecision {
+	for i, rule := range p.Rules {
+		if rule.matches(req) {
+			name := rule.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i)
+			}
+			return httpDecision{allowed: rule.Allow, rule: name}
+		}
+	}
+	return httpDecision{allowed: p.DefaultAllow}
+}
+
+func (r *HTTPRule) matches(req *http.Request) bool {
+	if len(r.Methods) > 0 && !containsFold(r.Methods, req.Method) {
+		return false
+	}
+	if len(r.Paths) > 0 && !matchAnyPath(r.Paths, path.Clean("/"+req.URL.Path)) {
+		return false
+	}
+	for name, pattern := range r.Headers {
+		values := req.Header.Values(name)
+		if len(values) == 0 {
+			values = []string{""}
+		}
+		// a repeated header must not get a request allowed by the value this rule checks and handled with another
+		matched := 0
+		for _, value := range values {
+			if ok, _ := path.Match(pattern, value); ok {
+				matched++
+			}
+		}
+		if matched == 0 || r.Allow && matched < len(values) {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanPath returns the canonical form of a request path, keeping its trailing slash
+func cleanPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAnyPath(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if base, ok := strings.CutSuffix(pattern, "/**"); ok {
+			// compare the base pattern with as many leading segments of the path
+			segments := strings.Split(p, "/")
+			if n := strings.Count(base, "/") + 1; len(segments) >= n {
+				if ok, _ := path.Match(base, strings.Join(segments[:n], "/")); ok {
+					return true
+				}
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalHTTPPolicies returns policies keyed by the canonical form of their addresses, see canonicalAddress
+func canonicalHTTPPolicies(policies map[string]*HTTPPolicy) map[string]*HTTPPolicy {
+	if policies == nil {
+		return nil
+	}
+	res := make(map[string]*HTTPPolicy, len(policies))
+	for address, policy := range policies {
+		res[canonicalAddress(address)] = policy
+	}
+	return res
+}
+
+// canonicalAddress returns the form of a host:port address compared with the addresses of the HTTP policies,
+// so "Kubernetes.Default.:443" matches "kubernetes.default:443" and "[::ffff:10.0.0.1]:443" matches "10.0.0.1:443"
+func canonicalAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		host = ip.Unmap().String()
+	} else {
+		host = strings.TrimSuffix(strings.ToLower(host), ".")
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// dial returns a connection served in HTTP mode, forwarding the allowed requests to address with next
+func (p *HTTPPolicy) dial(ctx context.Context, next Dialer, proto, address string, logger *slog.Logger) net.Conn {
+	if next == nil {
+		d := &net.Dialer{}
+		next = d.DialContext
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return next(ctx, proto, address)
+		},
+		TLSClientConfig: p.TLSConfig,
+	}
+	scheme := "http"
+	if p.TLSConfig != nil {
+		scheme = "https"
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL.Scheme = scheme
+			r.Out.URL.Host = address
+		},
+		Transport: transport,
+	}
+
+	client, server := net.Pipe()
+	l := newConnListener(server)
+	s := &http.Server{
+		Handler: p.handler(proxy, logger),
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			if state == http.StateClosed || state == http.StateHijacked {
+				_ = l.Close()
+			}
+		},
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithoutCancel(ctx)
+		},
+	}
+	go func() {
+		_ = s.Serve(l)
+		transport.CloseIdleConnections()
+	}()
+	return client
+}
+
+func (p *HTTPPolicy) handler(proxy http.Handler, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// the address gets the path the rules were evaluated against
+		req.URL.Path, req.URL.RawPath = cleanPath(req.URL.Path), ""
+		decision := p.evaluate(req)
+		if !decision.allowed {
+			logger.Info("Request denied by HTTP policy", "method", req.Method, "path", req.URL.Path, "rule", decision.rule)
+			writeHTTPDenial(rw, req, decision)
+			return
+		}
+		logger.Debug("Request allowed by HTTP policy", "method", req.Method, "path", req.URL.Path, "rule", decision.rule)
+
+		if len(p.Header) > 0 || p.BearerTokenFile != "" {
+			p.stripHeaders(req.Header)
+		}
+		for name, values := range p.Header {
+			req.Header[http.CanonicalHeaderKey(name)] = values
+		}
+		if p.BearerTokenFile != "" {
+			token, err := os.ReadFile(p.BearerTokenFile)
+			if err != nil {
+				logger.Error("Failed to read bearer token", "file", p.BearerTokenFile, "error", err)
+				http.Error(rw, "failed to read credentials", http.StatusBadGateway)
+				return
+			}
+			req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+		}
+		proxy.ServeHTTP(rw, req)
+	})
+}
+
+// stripHeaders removes the headers sent by the remote end that must not reach the address with the injected credentials
+func (p *HTTPPolicy) stripHeaders(header http.Header) {
+	names := p.StripHeaders
+	if names == nil {
+		names = DefaultHTTPStripHeaders
+	}
+	for _, name := range names {
+		prefix, ok := strings.CutSuffix(name, "*")
+		if !ok {
+			header.Del(name)
+			continue
+		}
+		for key := range header {
+			if len(key) >= len(prefix) && strings.EqualFold(key[:len(prefix)], prefix) {
+				delete(header, key)
+			}
+		}
+	}
+}
+
+// httpDenial is the body of the responses to denied requests, a Kubernetes Status
+type httpDenial struct {
+	Kind       string `json:"kind"`
+	APIVersion string `json:"apiVersion"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	Reason     string `json:"reason"`
+	Code       int    `json:"code"`
+}
+
+func writeHTTPDenial(rw http.ResponseWriter, req *http.Request, decision httpDecision) {
+	message := fmt.Sprintf("%s %s denied by policy", req.Method, req.URL.Path)
+	if decision.rule != "" {
+		message += " rule " + decision.rule
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(rw).Encode(httpDenial{
+		Kind:       "Status",
+		APIVersion: "v1",
+		Status:     "Failure",
+		Message:    message,
+		Reason:     "Forbidden",
+		Code:       http.StatusForbidden,
+	})
+}
+
+// connListener is a net.Listener accepting a single connection, closed once the connection is done
+type connListener struct {
+	conn      chan net.Conn
+	addr      net.Addr
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	l := &connListener{
+		conn: make(chan net.Conn, 1),
+		addr: conn.LocalAddr(),
+		done: make(chan struct{}),
+	}
+	l.conn <- conn
+	return l
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conn:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
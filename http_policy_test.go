@@ -0,0 +1,229 @@
+package remotedialer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPPolicy_evaluate(t *testing.T) {
+	p := &HTTPPolicy{
+		Rules: []HTTPRule{
+			{Name: "no-secrets", Allow: false, Paths: []string{"/api/v1/namespaces/*/secrets/**"}},
+			{Name: "read", Allow: true, Methods: []string{"get"}, Paths: []string{"/api/**", "/version"}},
+			{Name: "impersonation", Allow: false, Headers: map[string]string{"Impersonate-User": "?*"}},
+			{Name: "tenant", Allow: true, Headers: map[string]string{"X-Tenant": "a"}},
+		},
+		DefaultAllow: true,
+	}
+	tests := []struct {
+		method, path string
+		header       http.Header
+		allowed      bool
+		rule         string
+	}{
+		{method: "GET", path: "/api/v1/namespaces/default/pods", allowed: true, rule: "read"},
+		{method: "GET", path: "/api", allowed: true, rule: "read"},
+		{method: "GET", path: "/version", allowed: true, rule: "read"},
+		{method: "GET", path: "/api/v1/namespaces/default/secrets/token", rule: "no-secrets"},
+		{method: "GET", path: "/api/v1/namespaces/default/secrets", rule: "no-secrets"},
+		{method: "GET", path: "/api/../api/v1/namespaces/default/secrets", rule: "no-secrets"},
+		{method: "DELETE", path: "/apis/apps/v1", header: http.Header{"Impersonate-User": {"admin"}}, rule: "impersonation"},
+		{method: "DELETE", path: "/apis/apps/v1", header: http.Header{"Impersonate-User": {"", "admin"}}, rule: "impersonation"},
+		{method: "DELETE", path: "/apis/apps/v1", header: http.Header{"X-Tenant": {"a"}}, allowed: true, rule: "tenant"},
+		{method: "DELETE", path: "/apis/apps/v1", header: http.Header{"X-Tenant": {"a", "b"}}, allowed: true},
+		{method: "DELETE", path: "/apis/apps/v1", allowed: true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "http://kubernetes"+tt.path, nil)
+		for k, v := range tt.header {
+			req.Header[k] = v
+		}
+		if got := p.evaluate(req); got.allowed != tt.allowed || got.rule != tt.rule {
+			t.Errorf("%s %s: expected allowed=%v by %q, got allowed=%v by %q", tt.method, tt.path, tt.allowed, tt.rule, got.allowed, got.rule)
+		}
+	}
+}
+
+func TestCanonicalAddress(t *testing.T) {
+	tests := map[string]string{
+		"kubernetes.default:443":   "kubernetes.default:443",
+		"Kubernetes.Default.:443":  "kubernetes.default:443",
+		"[::ffff:10.0.0.1]:443":    "10.0.0.1:443",
+		"[FD00::1]:443":            "[fd00::1]:443",
+		"not an address":           "not an address",
+		"kubernetes.default.:6443": "kubernetes.default:6443",
+	}
+	for address, want := range tests {
+		if got := canonicalAddress(address); got != want {
+			t.Errorf("canonicalAddress(%q): got %q, want %q", address, got, want)
+		}
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := map[string]string{
+		"":                        "/",
+		"/":                       "/",
+		"/api/../api/v1/secrets":  "/api/v1/secrets",
+		"//api/./v1":              "/api/v1",
+		"/api/v1/namespaces/":     "/api/v1/namespaces/",
+		"/api/v1/../../version/":  "/version/",
+		"/../../etc/kubernetes//": "/etc/kubernetes/",
+	}
+	for p, want := range tests {
+		if got := cleanPath(p); got != want {
+			t.Errorf("cleanPath(%q): got %q, want %q", p, got, want)
+		}
+	}
+}
+
+func TestHTTPPolicy_stripHeaders(t *testing.T) {
+	header := http.Header{
+		"Authorization":         {"Bearer server-token"},
+		"Impersonate-User":      {"admin"},
+		"Impersonate-Extra-Foo": {"bar"},
+		"Accept":                {"application/json"},
+	}
+
+	p := &HTTPPolicy{}
+	stripped := header.Clone()
+	p.stripHeaders(stripped)
+	if len(stripped) != 1 || stripped.Get("Accept") == "" {
+		t.Errorf("expected the credentials and impersonation headers to be removed by default, got: %v", stripped)
+	}
+
+	p.StripHeaders = []string{}
+	kept := header.Clone()
+	p.stripHeaders(kept)
+	if len(kept) != len(header) {
+		t.Errorf("expected an empty list to keep every header, got: %v", kept)
+	}
+
+	p.StripHeaders = []string{"impersonate-extra-*"}
+	stripped = header.Clone()
+	p.stripHeaders(stripped)
+	if len(stripped) != 3 || stripped.Get("Impersonate-Extra-Foo") != "" {
+		t.Errorf("expected only the configured headers to be removed, got: %v", stripped)
+	}
+}
+
+func TestHTTPPolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Path", req.URL.EscapedPath())
+		_, _ = io.WriteString(rw, req.Header.Get("Authorization")+req.Header.Get("Impersonate-User"))
+	}))
+	defer upstream.Close()
+	upstreamAddress := strings.TrimPrefix(upstream.URL, "http://")
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("agent-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ClientOptions{
+		HTTPPolicies: map[string]*HTTPPolicy{
+			upstreamAddress: {
+				Rules:           []HTTPRule{{Name: "read", Allow: true, Methods: []string{http.MethodGet}}},
+				BearerTokenFile: tokenFile,
+			},
+		},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, nil)
+
+	dialer := server.Dialer("client")
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return dialer(ctx, network, address)
+			},
+		},
+	}
+
+	// the credentials sent by the remote end are replaced, and it can't act on behalf of another user with them
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL+"/api", nil)
+	req.Header.Set("Authorization", "Bearer server-token")
+	req.Header.Set("Impersonate-User", "admin")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "Bearer agent-token" {
+		t.Errorf("expected the agent credentials to be injected, got: %q", body)
+	}
+
+	// the address gets the path the rules were evaluated against
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL+"/api/v1/..%2F..%2Fversion", nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Path"); got != "/version" {
+		t.Errorf("expected the normalized path to be forwarded, got: %q", got)
+	}
+
+	req, _ = http.NewRequestWithContext(ctx, http.MethodDelete, upstream.URL+"/api", nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected the denied request to get a 403 response, got: %d", resp.StatusCode)
+	}
+	var status httpDenial
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Kind != "Status" || status.Reason != "Forbidden" || status.Code != http.StatusForbidden {
+		t.Errorf("unexpected denial: %+v", status)
+	}
+}
+
+func TestHTTPPolicyEquivalentAddresses(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := ClientOptions{
+		HTTPPolicies: map[string]*HTTPPolicy{"Kubernetes.Default:443": {}},
+		LocalDialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			t.Errorf("unexpected dial to %s, denied requests must not reach the address", address)
+			return nil, errors.New("unexpected dial")
+		},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, nil)
+
+	dialer := server.Dialer("client")
+	for _, address := range []string{"kubernetes.default:443", "KUBERNETES.default.:443"} {
+		client := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer(ctx, "tcp", address)
+				},
+			},
+		}
+		resp, err := client.Get("http://kubernetes/api")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: expected the request to be denied by the policy, got: %d", address, resp.StatusCode)
+		}
+	}
+}
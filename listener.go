@@ -0,0 +1,339 @@
+package remotedialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrListenForbidden is returned by Listen when the remote end doesn't allow opening the listener
+var ErrListenForbidden = errors.New("listen forbidden")
+
+// listenBacklog is the number of accepted connections waiting for a call to Accept, further ones are rejected
+const listenBacklog = 128
+
+var errListenBacklogFull = errors.New("listener backlog full")
+
+// remoteListener is a net.Listener whose connections are accepted by a listener opened on the remote end of a session
+type remoteListener struct {
+	session *Session
+	id      int64
+	addr    addr
+	conns   chan *connection
+
+	readyOnce sync.Once
+	ready     chan struct{}
+
+	lock sync.Mutex
+	// err is set and done closed once the listener is closed, by either end
+	err  error
+	done chan struct{}
+}
+
+// Listen opens a listener on the remote end of the session and returns a net.Listener accepting the connections it receives,
+// forwarding them through the tunnel. The remote end must allow it, client sessions do with ClientOptions.ListenAuthorizer.
+//
+// It waits for the remote end to confirm the listener is open, until ctx is done or for a minute if ctx has no deadline,
+// as peers older than this method ignore the request. The address of the listener on the remote end is returned by Addr.
+// The listener is closed with the session, accepted connections are closed independently.
+func (s *Session) Listen(ctx context.Context, proto, address string) (net.Listener, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, defaultDeadline())
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	l := &remoteListener{
+		session: s,
+		// listeners share the ID space of connections, so Error messages can close either
+		id:    atomic.AddInt64(&s.nextConnID, connIDStep),
+		addr:  addr{proto: proto, address: address},
+		conns: make(chan *connection, listenBacklog),
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	s.addListener(l)
+	if _, err := s.writeMessage(deadline, newListen(l.id, proto, address)); err != nil {
+		s.removeListener(l.id)
+		l.shutdown(err)
+		return nil, err
+	}
+
+	select {
+	case <-l.ready:
+		return l, nil
+	case <-l.done:
+		return nil, l.err
+	case <-ctx.Done():
+		_ = l.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Listen opens a listener on the host of the given client, see Session.Listen.
+// Only clients connected to this Server are supported, not those reachable through a peer.
+func (s *Server) Listen(ctx context.Context, clientKey, proto, address string) (net.Listener, error) {
+	session := s.sessions.getClientSession(clientKey)
+	if session == nil {
+		return nil, fmt.Errorf("failed to find Session for client %s", clientKey)
+	}
+	return session.Listen(ctx, proto, address)
+}
+
+// Accept waits for the next connection accepted by the listener on the remote end
+func (l *remoteListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// Close closes the listener on the remote end. Connections already returned by Accept are not closed.
+func (l *remoteListener) Close() error {
+	l.session.removeListener(l.id)
+	if !l.shutdown(net.ErrClosed) {
+		return nil
+	}
+	_, err := l.session.writeMessage(time.Now().Add(SendErrorTimeout), newErrorMessage(l.id, io.EOF))
+	return err
+}
+
+// Addr returns the address the remote end listens on
+func (l *remoteListener) Addr() net.Addr {
+	return l.addr
+}
+
+// setReady records the address the remote end listens on, completing Listen
+func (l *remoteListener) setReady(address string) {
+	l.readyOnce.Do(func() {
+		l.addr.address = address
+		close(l.ready)
+	})
+}
+
+// enqueue hands an accepted connection to Accept, returning false if the listener is closed or its backlog is full
+func (l *remoteListener) enqueue(conn *connection) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.err != nil {
+		return false
+	}
+	select {
+	case l.conns <- conn:
+		return true
+	default:
+		return false
+	}
+}
+
+// shutdown closes the listener with err, returned by further calls to Accept. It returns false if it was already closed.
+// Connections accepted and not returned by Accept yet are closed.
+func (l *remoteListener) shutdown(err error) bool {
+	l.lock.Lock()
+	if l.err != nil {
+		l.lock.Unlock()
+		return false
+	}
+	l.err = err
+	close(l.done)
+	var pending []*connection
+	for len(l.conns) > 0 {
+		pending = append(pending, <-l.conns)
+	}
+	l.lock.Unlock()
+
+	for _, conn := range pending {
+		_ = conn.Close()
+	}
+	return true
+}
+
+// addListener registers a listener opened on the remote end by Listen
+func (s *Session) addListener(l *remoteListener) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.listeners == nil {
+		s.listeners = map[int64]*remoteListener{}
+	}
+	s.listeners[l.id] = l
+}
+
+// removeListener unregisters a listener opened on the remote end by Listen
+func (s *Session) removeListener(id int64) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.listeners, id)
+}
+
+// getListener retrieves a listener opened on the remote end by Listen
+func (s *Session) getListener(id int64) *remoteListener {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.listeners[id]
+}
+
+// addLocalListener registers a listener opened by this end on behalf of the remote end
+func (s *Session) addLocalListener(id int64, listener net.Listener) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.localListeners == nil {
+		s.localListeners = map[int64]net.Listener{}
+	}
+	s.localListeners[id] = listener
+}
+
+// removeLocalListener unregisters a listener opened by this end on behalf of the remote end, returning it if it was still registered
+func (s *Session) removeLocalListener(id int64) net.Listener {
+	s.Lock()
+	defer s.Unlock()
+
+	listener := s.localListeners[id]
+	delete(s.localListeners, id)
+	return listener
+}
+
+// closeListener closes the listener with the given ID, opened by either end, after receiving an Error message for it
+func (s *Session) closeListener(id int64, err error) {
+	if l := s.getListener(id); l != nil {
+		s.removeListener(id)
+		l.shutdown(err)
+	}
+	if listener := s.removeLocalListener(id); listener != nil {
+		_ = listener.Close()
+	}
+}
+
+// closeListeners closes all the listeners of the session, opened by either end
+func (s *Session) closeListeners(err error) {
+	s.Lock()
+	listeners, localListeners := s.listeners, s.localListeners
+	s.listeners, s.localListeners = nil, nil
+	s.Unlock()
+
+	for _, l := range listeners {
+		l.shutdown(err)
+	}
+	for _, listener := range localListeners {
+		_ = listener.Close()
+	}
+}
+
+// listen opens a listener requested by the remote end, forwarding it the connections it accepts
+func (s *Session) listen(ctx context.Context, message *message) {
+	if s.listenAuth == nil || !s.listenAuth(message.proto, message.address) {
+		s.logger.Debug("Listen not allowed", "proto", message.proto, "address", message.address)
+		_, _ = s.writeMessage(time.Now().Add(SendErrorTimeout), newErrorMessage(message.connID, ErrListenForbidden))
+		return
+	}
+
+	var lc net.ListenConfig
+	listener, err := lc.Listen(ctx, message.proto, message.address)
+	if err != nil {
+		_, _ = s.writeMessage(time.Now().Add(SendErrorTimeout), newErrorMessage(message.connID, err))
+		return
+	}
+	s.addLocalListener(message.connID, listener)
+	if _, err := s.writeMessage(defaultDeadline(), newListenReady(message.connID, listener.Addr().String())); err != nil {
+		s.removeLocalListener(message.connID)
+		_ = listener.Close()
+		return
+	}
+
+	go s.serveListener(ctx, message.connID, listener)
+}
+
+// serveListener forwards the connections accepted by a listener opened on behalf of the remote end, until it's closed
+func (s *Session) serveListener(ctx context.Context, listenerID int64, listener net.Listener) {
+	for {
+		netConn, err := listener.Accept()
+		if err != nil {
+			// listeners closed by either end are unregistered first, the remote end is only told about failures
+			if s.removeLocalListener(listenerID) != nil {
+				_ = listener.Close()
+				_, _ = s.writeMessage(time.Now().Add(SendErrorTimeout), newErrorMessage(listenerID, err))
+			}
+			return
+		}
+		s.forwardAccepted(ctx, listenerID, netConn)
+	}
+}
+
+// forwardAccepted opens a connection to the remote end for a connection accepted by one of the listeners it requested
+func (s *Session) forwardAccepted(ctx context.Context, listenerID int64, netConn net.Conn) {
+	release, err := s.admit(ctx, false)
+	if err != nil {
+		s.logger.Debug("Rejected accepted connection", "remoteAddress", netConn.RemoteAddr().String(), "error", err)
+		_ = netConn.Close()
+		return
+	}
+
+	remote := netConn.RemoteAddr()
+	connID := atomic.AddInt64(&s.nextConnID, connIDStep)
+	conn := newConnection(ctx, connID, s, remote.Network(), remote.String(), true)
+	conn.release = release
+	s.startConnectionSpan(ctx, conn, trace.SpanKindClient)
+	s.addConnection(connID, conn)
+
+	if _, err := s.writeMessage(defaultDeadline(), newListenAccept(connID, listenerID, remote.String())); err != nil {
+		s.closeConnection(connID, err)
+		_ = netConn.Close()
+		return
+	}
+
+	go func() {
+		defer conn.Close()
+		defer netConn.Close()
+		pipe(conn, netConn)
+	}()
+}
+
+// listenerReady completes Listen once the remote end opened the listener
+func (s *Session) listenerReady(message *message) {
+	if l := s.getListener(message.connID); l != nil {
+		l.setReady(message.address)
+	}
+}
+
+// listenerAccept accepts a connection received by a listener opened on the remote end by Listen
+func (s *Session) listenerAccept(ctx context.Context, message *message) {
+	l := s.getListener(message.listenerID)
+	if l == nil {
+		_, _ = s.writeMessage(time.Now().Add(SendErrorTimeout), newErrorMessage(message.connID, net.ErrClosed))
+		return
+	}
+	if s.getConnection(message.connID) != nil {
+		err := fmt.Errorf("connection ID %d already in use", message.connID)
+		_, _ = s.writeMessage(time.Now().Add(SendErrorTimeout), newErrorMessage(message.connID, err))
+		return
+	}
+
+	release, err := s.admit(ctx, false)
+	if err != nil {
+		_, _ = s.writeMessage(time.Now().Add(SendErrorTimeout), newErrorMessage(message.connID, err))
+		return
+	}
+
+	conn := newConnection(ctx, message.connID, s, l.addr.proto, message.address, false)
+	conn.release = release
+	s.startConnectionSpan(ctx, conn, trace.SpanKindServer)
+	s.addConnection(message.connID, conn)
+
+	if !l.enqueue(conn) {
+		s.closeConnection(message.connID, errListenBacklogFull)
+	}
+}
@@ -0,0 +1,80 @@
+package remotedialer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerListen(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := ClientOptions{
+		ListenAuthorizer: func(proto, address string) bool {
+			return address == "127.0.0.1:0"
+		},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, nil)
+
+	if _, err := server.Listen(ctx, "client", "tcp", "0.0.0.0:0"); !errors.Is(err, ErrListenForbidden) {
+		t.Errorf("expected a listener not allowed by the client to be forbidden, got: %v", err)
+	}
+
+	l, err := server.Listen(ctx, "client", "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Addr().String() == "127.0.0.1:0" {
+		t.Errorf("expected the address the client listens on, got: %s", l.Addr())
+	}
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	// connections to the listener on the client host reach the server
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	} else if string(buf) != "hello" {
+		t.Errorf("unexpected echo, got: %q", buf)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected Accept to fail with net.ErrClosed after Close, got: %v", err)
+	}
+	// the listener is closed on the client host too
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			break
+		}
+		c.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("the listener is still open on the client host")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
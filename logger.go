@@ -0,0 +1,104 @@
+package remotedialer
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LevelTrace is the slog level used for very verbose messages, such as every ping sent
+const LevelTrace = slog.LevelDebug - 4
+
+// Attribute keys used consistently in every log record
+const (
+	LogKeyClientKey  = "clientKey"
+	LogKeySessionKey = "sessionKey"
+	LogKeyConnID     = "connID"
+	LogKeyPeer       = "peer"
+)
+
+// defaultLogger returns a logger sending records to the global logrus logger, used when no other logger is provided
+func defaultLogger() *slog.Logger {
+	return slog.New(logrusHandler{})
+}
+
+func loggerOrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return defaultLogger()
+	}
+	return logger
+}
+
+// logrusHandler is a slog.Handler writing to the global logrus logger, keeping the attributes as logrus fields
+type logrusHandler struct {
+	fields logrus.Fields
+	group  string
+}
+
+func (h logrusHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return logrus.IsLevelEnabled(toLogrusLevel(level))
+}
+
+func (h logrusHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := make(logrus.Fields, len(h.fields)+record.NumAttrs())
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		h.addAttr(fields, h.group, attr)
+		return true
+	})
+	logrus.WithFields(fields).Log(toLogrusLevel(record.Level), record.Message)
+	return nil
+}
+
+func (h logrusHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make(logrus.Fields, len(h.fields)+len(attrs))
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	for _, attr := range attrs {
+		h.addAttr(fields, h.group, attr)
+	}
+	return logrusHandler{fields: fields, group: h.group}
+}
+
+func (h logrusHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return logrusHandler{fields: h.fields, group: h.group + name + "."}
+}
+
+func (h logrusHandler) addAttr(fields logrus.Fields, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, a := range value.Group() {
+			h.addAttr(fields, prefix, a)
+		}
+		return
+	}
+	if attr.Key == "" {
+		return
+	}
+	fields[prefix+attr.Key] = value.Any()
+}
+
+func toLogrusLevel(level slog.Level) logrus.Level {
+	switch {
+	case level >= slog.LevelError:
+		return logrus.ErrorLevel
+	case level >= slog.LevelWarn:
+		return logrus.WarnLevel
+	case level >= slog.LevelInfo:
+		return logrus.InfoLevel
+	case level >= slog.LevelDebug:
+		return logrus.DebugLevel
+	default:
+		return logrus.TraceLevel
+	}
+}
@@ -0,0 +1,99 @@
+package remotedialer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+type syncBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.String()
+}
+
+func TestServer_Logger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var buf syncBuffer
+	server := New(func(*http.Request) (string, bool, error) { return "client", true, nil }, DefaultErrorWriter)
+	server.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	address, err := newServer(ctx, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newTestClient(ctx, "ws://"+address); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.WaitForSession(ctx, "client"); err != nil {
+		t.Fatal(err)
+	}
+
+	var record map[string]any
+	if err := json.NewDecoder(bytes.NewBufferString(buf.String())).Decode(&record); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := record["msg"], "Handling backend connection request"; got != want {
+		t.Errorf("incorrect message, got: %v, want: %v", got, want)
+	}
+	if got, want := record[LogKeyClientKey], "client"; got != want {
+		t.Errorf("incorrect client key attribute, got: %v, want: %v", got, want)
+	}
+}
+
+func TestLogrusHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.StandardLogger()
+	out, level, formatter := logger.Out, logger.Level, logger.Formatter
+	defer func() {
+		logger.SetOutput(out)
+		logger.SetLevel(level)
+		logger.SetFormatter(formatter)
+	}()
+	logger.SetOutput(&buf)
+	logger.SetLevel(logrus.InfoLevel)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+
+	l := defaultLogger().With(LogKeyClientKey, "c1").WithGroup("conn")
+	l.Debug("not logged")
+	l.Warn("logged", LogKeyConnID, 5)
+
+	// other tests may be logging concurrently, so look for the expected entry
+	var entry map[string]any
+	for _, line := range bytes.Split(buf.Bytes(), []byte("\n")) {
+		var e map[string]any
+		if json.Unmarshal(line, &e) == nil && e["msg"] != "not logged" && e[LogKeyClientKey] == "c1" {
+			entry = e
+		}
+	}
+	if entry == nil {
+		t.Fatalf("entry not found in output %q", buf.String())
+	}
+	if got, want := entry["level"], "warning"; got != want {
+		t.Errorf("incorrect level, got: %v, want: %v", got, want)
+	}
+	if got, want := entry[LogKeyClientKey], "c1"; got != want {
+		t.Errorf("incorrect client key field, got: %v, want: %v", got, want)
+	}
+	if got, want := entry["conn."+LogKeyConnID], 5.0; got != want {
+		t.Errorf("incorrect grouped field, got: %v, want: %v", got, want)
+	}
+}
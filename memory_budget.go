@@ -0,0 +1,129 @@
+package remotedialer
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrMemoryBudgetExceeded is the error closing connections evicted to keep the buffered data within a MemoryBudget
+var ErrMemoryBudgetExceeded = errors.New("memory budget exceeded")
+
+// pressurePauseThreshold is the buffered size above which connections are paused while their MemoryBudget is under pressure,
+// instead of MaxBuffer. It's kept above the resume threshold, so paused connections don't resume right away.
+const pressurePauseThreshold = MaxBuffer / 4
+
+// MemoryBudget bounds the data received and not read yet by all the connections sharing it.
+// It can be shared by several Servers and client sessions to bound the memory used by the whole process.
+//
+// Once 3/4 of the budget is used, connections are paused earlier. Once it's exceeded, the connections
+// buffering the most data are closed with ErrMemoryBudgetExceeded, until the budget is met again.
+type MemoryBudget struct {
+	limit int64
+
+	lock      sync.Mutex
+	used      int64
+	consumers map[*connection]int64
+}
+
+// NewMemoryBudget creates a MemoryBudget allowing up to limit bytes to be buffered
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{
+		limit:     limit,
+		consumers: map[*connection]int64{},
+	}
+}
+
+// Limit returns the maximum number of bytes that can be buffered
+func (b *MemoryBudget) Limit() int64 {
+	return b.limit
+}
+
+// Used returns the number of bytes currently buffered
+func (b *MemoryBudget) Used() int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.used
+}
+
+// reserve accounts for n bytes buffered by conn. It reports whether the budget is under pressure,
+// and returns the connections to evict to get back within the limit.
+func (b *MemoryBudget) reserve(conn *connection, n int) (pressure bool, evict []*connection) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.consumers[conn] += int64(n)
+	b.used += int64(n)
+
+	// evicted connections are forgotten right away, so they are not picked again by concurrent reservations
+	for b.used > b.limit && len(b.consumers) > 0 {
+		var (
+			largest *connection
+			size    int64
+		)
+		for c, s := range b.consumers {
+			if s > size {
+				largest, size = c, s
+			}
+		}
+		if largest == nil {
+			break
+		}
+		delete(b.consumers, largest)
+		b.used -= size
+		evict = append(evict, largest)
+	}
+	return b.used > b.limit/4*3, evict
+}
+
+// release accounts for n bytes read or discarded by conn
+func (b *MemoryBudget) release(conn *connection, n int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	size, ok := b.consumers[conn]
+	if !ok {
+		// already evicted
+		return
+	}
+	released := min(int64(n), size)
+	b.used -= released
+	if size == released {
+		delete(b.consumers, conn)
+	} else {
+		b.consumers[conn] = size - released
+	}
+}
+
+// buffered accounts for delta bytes added to (if positive) or removed from the read buffer of the connection.
+// Connections are paused earlier if the memory budget is under pressure, and evicted if it's exceeded.
+func (c *connection) buffered(delta int) {
+	c.session.metrics.BufferedBytes(c.session.clientKey, delta)
+
+	budget := c.session.budget
+	if budget == nil || delta == 0 {
+		return
+	}
+	if delta < 0 {
+		budget.release(c, -delta)
+		c.session.metrics.MemoryBudgetUsage(budget.Used(), budget.Limit())
+		return
+	}
+
+	pressure, evict := budget.reserve(c, delta)
+	c.session.metrics.MemoryBudgetUsage(budget.Used(), budget.Limit())
+	if pressure {
+		if _, size := c.buffer.stats(); size > pressurePauseThreshold {
+			c.backPressure.Pause()
+		}
+	}
+	for _, conn := range evict {
+		conn.evict()
+	}
+}
+
+// evict closes the connection and drops its buffered data to free memory
+func (c *connection) evict() {
+	c.logger.Warn("Closing connection to stay within the memory budget", "buffered", c.Stats().Buffered)
+	c.session.metrics.MemoryBudgetEviction(c.session.clientKey)
+	c.abort(ErrMemoryBudgetExceeded)
+}
@@ -0,0 +1,68 @@
+package remotedialer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	t.Parallel()
+
+	s := setupDummySession(t, 0)
+	s.conn = &fakeWSConn{
+		writeMessageCallback: func(int, time.Time, []byte) error {
+			return nil
+		},
+	}
+	budget := NewMemoryBudget(MaxBuffer)
+	s.budget = budget
+
+	newConn := func() *connection {
+		connID := getDummyConnectionID()
+		conn := newConnection(context.Background(), connID, s, "tcp", "test", true)
+		s.addConnection(connID, conn)
+		return conn
+	}
+	offer := func(conn *connection, n int) {
+		t.Helper()
+		if err := conn.OnData(bytes.NewReader(make([]byte, n))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	large, small := newConn(), newConn()
+
+	offer(large, MaxBuffer/2)
+	if large.Stats().Paused {
+		t.Error("connection should not be paused while the budget is not under pressure")
+	}
+
+	offer(small, MaxBuffer/4+MaxRead)
+	if !small.Stats().Paused {
+		t.Error("connection should be paused earlier while the budget is under pressure")
+	}
+
+	offer(large, MaxBuffer/4)
+	if got, want := budget.Used(), int64(MaxBuffer/4+MaxRead); got != want {
+		t.Errorf("incorrect budget usage after eviction, got: %d, want: %d", got, want)
+	}
+	if s.getConnection(large.connID) != nil {
+		t.Error("the largest connection should have been evicted")
+	}
+	if _, err := large.Read(make([]byte, 1)); !errors.Is(err, ErrMemoryBudgetExceeded) {
+		t.Errorf("expected the evicted connection to fail with ErrMemoryBudgetExceeded, got: %v", err)
+	}
+	if s.getConnection(small.connID) == nil {
+		t.Error("the smallest connection should have been kept")
+	}
+
+	if _, err := io.ReadFull(small, make([]byte, MaxBuffer/4+MaxRead)); err != nil {
+		t.Fatal(err)
+	}
+	if got := budget.Used(); got != 0 {
+		t.Errorf("expected the budget to be released after reading, got %d bytes used", got)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -35,8 +37,42 @@ const (
 	// SyncConnections is a message type used to communicate active connection IDs.
 	// The receiver can consider any ID not present in this message as stale and free any associated resource.
 	SyncConnections
+	// SyncConnectionRanges is the compact form of SyncConnections, sent by both ends of a session.
+	// Its payload is a version byte followed by ranges of consecutive IDs, see encodeConnectionRanges.
+	// Peers older than this message type ignore it.
+	SyncConnectionRanges
+	// Listen is a control message type, used to request opening a listener on the remote end.
+	// Its connID is the ID of the listener, allocated like connection IDs, and closing the listener is requested with an Error message.
+	Listen
+	// ListenReady is a message type used to confirm a listener was opened, carrying the address it listens on
+	ListenReady
+	// ListenAccept is a message type used to open a new connection accepted by a listener, identified in its payload
+	ListenAccept
 )
 
+const (
+	// maxConnectAddress is the maximum length of the proto/address payload of a Connect message
+	maxConnectAddress = 266
+	// maxConnectExtension is the maximum length of the extension following the address in a Connect message
+	maxConnectExtension = 4096
+	// connectExtensionSeparator separates the address from the extension in a Connect message
+	connectExtensionSeparator = "\x00"
+	// maxListenPayload is the maximum length of the payload of Listen, ListenReady and ListenAccept messages,
+	// the latter prefixing the address with the listener ID
+	maxListenPayload = maxConnectAddress + 21
+	// connectExtensionVersion is the first byte of the extension, identifying its encoding.
+	// Version 1 is a URL encoded query, where trace context keys are prefixed by "t." and metadata keys by "m.".
+	connectExtensionVersion = '1'
+)
+
+var errConnectExtensionTooLarge = fmt.Errorf("dial metadata and trace context exceed %d bytes", maxConnectExtension)
+
+// ErrDialAborted is sent to the remote end when the caller of a dial gives up, so it stops dialing
+var ErrDialAborted = errors.New("dial aborted by the caller")
+
+// knownErrors are the errors recognized when received from the remote end, so callers can check them with errors.Is
+var knownErrors = []error{ErrIdleTimeout, ErrPausedTooLong, ErrMemoryBudgetExceeded, ErrDialAborted, ErrDialForbidden, ErrListenForbidden, ErrEndpointNotFound}
+
 var (
 	idCounter      int64
 	legacyDeadline = (15 * time.Second).Milliseconds()
@@ -49,6 +85,36 @@ func init() {
 
 type messageType int64
 
+func (t messageType) String() string {
+	switch t {
+	case Data:
+		return "DATA"
+	case Connect:
+		return "CONNECT"
+	case Error:
+		return "ERROR"
+	case AddClient:
+		return "ADDCLIENT"
+	case RemoveClient:
+		return "REMOVECLIENT"
+	case Pause:
+		return "PAUSE"
+	case Resume:
+		return "RESUME"
+	case SyncConnections:
+		return "SYNCCONNS"
+	case SyncConnectionRanges:
+		return "SYNCRANGES"
+	case Listen:
+		return "LISTEN"
+	case ListenReady:
+		return "LISTENREADY"
+	case ListenAccept:
+		return "LISTENACCEPT"
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", int64(t))
+}
+
 type message struct {
 	id          int64
 	err         error
@@ -58,6 +124,14 @@ type message struct {
 	body        io.Reader
 	proto       string
 	address     string
+	// traceContext holds the propagated trace context of Connect messages, if any
+	traceContext map[string]string
+	// metadata holds the dial metadata of Connect messages, if any
+	metadata map[string]string
+	// dialTimeout is the time left to the caller of a Connect message to get the connection, zero if unknown
+	dialTimeout time.Duration
+	// listenerID is the listener which accepted the connection of ListenAccept messages
+	listenerID int64
 }
 
 func nextid() int64 {
@@ -89,15 +163,34 @@ func newResume(connID int64) *message {
 	}
 }
 
-func newConnect(connID int64, proto, address string) *message {
-	return &message{
-		id:          nextid(),
-		connID:      connID,
-		messageType: Connect,
-		bytes:       []byte(fmt.Sprintf("%s/%s", proto, address)),
-		proto:       proto,
-		address:     address,
+// newConnect creates a Connect message.
+// The extension carrying trace context and metadata is only sent if any of them is not empty, as older peers can't parse it.
+func newConnect(connID int64, proto, address string, traceContext, metadata map[string]string) (*message, error) {
+	payload := fmt.Sprintf("%s/%s", proto, address)
+	if len(traceContext) > 0 || len(metadata) > 0 {
+		values := url.Values{}
+		for k, v := range traceContext {
+			values.Set("t."+k, v)
+		}
+		for k, v := range metadata {
+			values.Set("m."+k, v)
+		}
+		encoded := values.Encode()
+		if len(encoded)+1 > maxConnectExtension {
+			return nil, errConnectExtensionTooLarge
+		}
+		payload += connectExtensionSeparator + string(connectExtensionVersion) + encoded
 	}
+	return &message{
+		id:           nextid(),
+		connID:       connID,
+		messageType:  Connect,
+		bytes:        []byte(payload),
+		proto:        proto,
+		address:      address,
+		traceContext: traceContext,
+		metadata:     metadata,
+	}, nil
 }
 
 func newErrorMessage(connID int64, err error) *message {
@@ -128,6 +221,38 @@ func newRemoveClient(client string) *message {
 	}
 }
 
+func newListen(listenerID int64, proto, address string) *message {
+	return &message{
+		id:          nextid(),
+		connID:      listenerID,
+		messageType: Listen,
+		bytes:       []byte(fmt.Sprintf("%s/%s", proto, address)),
+		proto:       proto,
+		address:     address,
+	}
+}
+
+func newListenReady(listenerID int64, address string) *message {
+	return &message{
+		id:          nextid(),
+		connID:      listenerID,
+		messageType: ListenReady,
+		bytes:       []byte(address),
+		address:     address,
+	}
+}
+
+func newListenAccept(connID, listenerID int64, address string) *message {
+	return &message{
+		id:          nextid(),
+		connID:      connID,
+		messageType: ListenAccept,
+		bytes:       []byte(fmt.Sprintf("%d/%s", listenerID, address)),
+		address:     address,
+		listenerID:  listenerID,
+	}
+}
+
 func newServerMessage(reader io.Reader) (*message, error) {
 	buf := bufio.NewReader(reader)
 
@@ -154,19 +279,24 @@ func newServerMessage(reader io.Reader) (*message, error) {
 	}
 
 	if m.messageType == Data || m.messageType == Connect {
-		// no longer used, this is the deadline field
-		_, err := binary.ReadVarint(buf)
+		// this is the legacy deadline field, now only carrying the dial timeout of Connect messages if negative
+		deadline, err := binary.ReadVarint(buf)
 		if err != nil {
 			return nil, err
 		}
+		if m.messageType == Connect && deadline < 0 {
+			m.dialTimeout = time.Duration(-deadline) * time.Millisecond
+		}
 	}
 
 	if m.messageType == Connect {
-		bytes, err := ioutil.ReadAll(io.LimitReader(buf, 266))
+		bytes, err := ioutil.ReadAll(io.LimitReader(buf, int64(maxConnectAddress+len(connectExtensionSeparator)+maxConnectExtension)))
 		if err != nil {
 			return nil, err
 		}
-		parts := strings.SplitN(string(bytes), "/", 2)
+		payload, extension, _ := strings.Cut(string(bytes), connectExtensionSeparator)
+		m.traceContext, m.metadata = parseConnectExtension(extension)
+		parts := strings.SplitN(payload, "/", 2)
 		if len(parts) != 2 {
 			return nil, fmt.Errorf("failed to parse connect address")
 		}
@@ -180,11 +310,68 @@ func newServerMessage(reader io.Reader) (*message, error) {
 		}
 		m.address = string(bytes)
 		m.bytes = bytes
+	} else if m.messageType == Listen || m.messageType == ListenReady || m.messageType == ListenAccept {
+		bytes, err := ioutil.ReadAll(io.LimitReader(buf, maxListenPayload))
+		if err != nil {
+			return nil, err
+		}
+		if err := m.parseListenPayload(string(bytes)); err != nil {
+			return nil, err
+		}
+		m.bytes = bytes
 	}
 
 	return m, nil
 }
 
+// parseListenPayload decodes the payload of Listen, ListenReady and ListenAccept messages
+func (m *message) parseListenPayload(payload string) error {
+	switch m.messageType {
+	case Listen:
+		proto, address, ok := strings.Cut(payload, "/")
+		if !ok {
+			return fmt.Errorf("failed to parse listen address")
+		}
+		m.proto, m.address = proto, address
+	case ListenReady:
+		m.address = payload
+	case ListenAccept:
+		id, address, ok := strings.Cut(payload, "/")
+		listenerID, err := strconv.ParseInt(id, 10, 64)
+		if !ok || err != nil {
+			return fmt.Errorf("failed to parse accepted connection")
+		}
+		m.listenerID, m.address = listenerID, address
+	}
+	return nil
+}
+
+// parseConnectExtension decodes the trace context and metadata of a Connect message.
+// Malformed or unknown versions are ignored, the connection is then handled as if none was sent.
+func parseConnectExtension(extension string) (traceContext, metadata map[string]string) {
+	if extension == "" || extension[0] != connectExtensionVersion {
+		return nil, nil
+	}
+	values, err := url.ParseQuery(extension[1:])
+	if err != nil {
+		return nil, nil
+	}
+	for k := range values {
+		if key, ok := strings.CutPrefix(k, "t."); ok {
+			if traceContext == nil {
+				traceContext = map[string]string{}
+			}
+			traceContext[key] = values.Get(k)
+		} else if key, ok := strings.CutPrefix(k, "m."); ok {
+			if metadata == nil {
+				metadata = map[string]string{}
+			}
+			metadata[key] = values.Get(k)
+		}
+	}
+	return traceContext, metadata
+}
+
 func (m *message) Err() error {
 	if m.err != nil {
 		return m.err
@@ -197,8 +384,15 @@ func (m *message) Err() error {
 	str := string(bytes)
 	if str == "EOF" {
 		m.err = io.EOF
+	} else if limitErr, ok := parseConnectionLimitError(str); ok {
+		m.err = limitErr
 	} else {
 		m.err = errors.New(str)
+		for _, known := range knownErrors {
+			if str == known.Error() {
+				m.err = known
+			}
+		}
 	}
 	return m.err
 }
@@ -213,7 +407,10 @@ func (m *message) header(space int) []byte {
 	offset += binary.PutVarint(buf[offset:], m.id)
 	offset += binary.PutVarint(buf[offset:], m.connID)
 	offset += binary.PutVarint(buf[offset:], int64(m.messageType))
-	if m.messageType == Data || m.messageType == Connect {
+	if m.messageType == Connect && m.dialTimeout > 0 {
+		// negative values carry the dial timeout, telling them apart from the constant sent by older peers
+		offset += binary.PutVarint(buf[offset:], -max(m.dialTimeout.Milliseconds(), 1))
+	} else if m.messageType == Data || m.messageType == Connect {
 		offset += binary.PutVarint(buf[offset:], legacyDeadline)
 	}
 	return buf[:offset]
@@ -232,7 +429,7 @@ func (m *message) String() string {
 	switch m.messageType {
 	case Data:
 		if m.body == nil {
-			return fmt.Sprintf("%d DATA         [%d]: %d bytes: %s", m.id, m.connID, len(m.bytes), string(m.bytes))
+			return fmt.Sprintf("%d DATA         [%d]: %d bytes", m.id, m.connID, len(m.bytes))
 		}
 		return fmt.Sprintf("%d DATA         [%d]: buffered", m.id, m.connID)
 	case Error:
@@ -249,6 +446,14 @@ func (m *message) String() string {
 		return fmt.Sprintf("%d RESUME       [%d]", m.id, m.connID)
 	case SyncConnections:
 		return fmt.Sprintf("%d SYNCCONNS    [%d]", m.id, m.connID)
+	case SyncConnectionRanges:
+		return fmt.Sprintf("%d SYNCRANGES   [%d]", m.id, m.connID)
+	case Listen:
+		return fmt.Sprintf("%d LISTEN       [%d]: %s/%s", m.id, m.connID, m.proto, m.address)
+	case ListenReady:
+		return fmt.Sprintf("%d LISTENREADY  [%d]: %s", m.id, m.connID, m.address)
+	case ListenAccept:
+		return fmt.Sprintf("%d LISTENACCEPT [%d]: %d/%s", m.id, m.connID, m.listenerID, m.address)
 	}
 	return fmt.Sprintf("%d UNKNOWN[%d]: %d", m.id, m.connID, m.messageType)
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewServerMessage_266(t *testing.T) {
@@ -44,3 +45,93 @@ func TestNewServerMessage_266(t *testing.T) {
 		t.Errorf("Expected msg.bytes to equal connectString bytes, got: %v", string(msg.bytes))
 	}
 }
+
+func TestNewServerMessage_ConnectExtension(t *testing.T) {
+	traceContext := map[string]string{
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"tracestate":  "vendor=a=b,other=c",
+	}
+
+	metadata := map[string]string{"user": "u-12345", "purpose": "kubectl exec"}
+
+	connect, err := newConnect(1, "tcp", "example.com:443", traceContext, metadata)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	msg, err := newServerMessage(bytes.NewReader(connect.Bytes()))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if msg.proto != "tcp" || msg.address != "example.com:443" {
+		t.Errorf("Expected tcp/example.com:443, got: %s/%s", msg.proto, msg.address)
+	}
+	for k, v := range traceContext {
+		if got := msg.traceContext[k]; got != v {
+			t.Errorf("Expected trace context %s to be %q, got: %q", k, v, got)
+		}
+	}
+	for k, v := range metadata {
+		if got := msg.metadata[k]; got != v {
+			t.Errorf("Expected metadata %s to be %q, got: %q", k, v, got)
+		}
+	}
+
+	// without trace context nor metadata the payload must remain readable by older peers
+	plain, err := newConnect(1, "tcp", "example.com:443", nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if string(plain.bytes) != "tcp/example.com:443" {
+		t.Errorf("Expected plain connect payload, got: %q", plain.bytes)
+	}
+
+	if _, err := newConnect(1, "tcp", "example.com:443", nil, map[string]string{"large": strings.Repeat("x", maxConnectExtension)}); err == nil {
+		t.Error("Expected an error for metadata exceeding the maximum size")
+	}
+}
+
+func TestNewServerMessage_ConnectDialTimeout(t *testing.T) {
+	connect, err := newConnect(1, "tcp", "example.com:443", nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// older peers always send the legacy deadline, which is ignored
+	msg, err := newServerMessage(bytes.NewReader(connect.Bytes()))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if msg.dialTimeout != 0 {
+		t.Errorf("Expected no dial timeout, got: %s", msg.dialTimeout)
+	}
+
+	connect.dialTimeout = 2500 * time.Millisecond
+	msg, err = newServerMessage(bytes.NewReader(connect.Bytes()))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if msg.dialTimeout != connect.dialTimeout {
+		t.Errorf("Expected dial timeout %s, got: %s", connect.dialTimeout, msg.dialTimeout)
+	}
+	if msg.proto != "tcp" || msg.address != "example.com:443" {
+		t.Errorf("Expected tcp/example.com:443, got: %s/%s", msg.proto, msg.address)
+	}
+}
+
+func TestNewServerMessage_Listen(t *testing.T) {
+	msg, err := newServerMessage(bytes.NewReader(newListen(2, "tcp", "127.0.0.1:8080").Bytes()))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if msg.connID != 2 || msg.proto != "tcp" || msg.address != "127.0.0.1:8080" {
+		t.Errorf("Expected listener 2 on tcp/127.0.0.1:8080, got: %d on %s/%s", msg.connID, msg.proto, msg.address)
+	}
+
+	msg, err = newServerMessage(bytes.NewReader(newListenAccept(3, 2, "10.0.0.1:51234").Bytes()))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if msg.connID != 3 || msg.listenerID != 2 || msg.address != "10.0.0.1:51234" {
+		t.Errorf("Expected connection 3 accepted by listener 2 from 10.0.0.1:51234, got: %d by %d from %s", msg.connID, msg.listenerID, msg.address)
+	}
+}
@@ -0,0 +1,307 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// collectors holds a full set of remotedialer metrics
+type collectors struct {
+	totalAddWS                  *prometheus.CounterVec
+	totalRemoveWS               *prometheus.CounterVec
+	totalAddConnectionsForWS    *prometheus.CounterVec
+	totalRemoveConnectionsForWS *prometheus.CounterVec
+	totalTransmitBytesOnWS      *prometheus.CounterVec
+	totalTransmitErrorBytesOnWS *prometheus.CounterVec
+	totalReceiveBytesOnWS       *prometheus.CounterVec
+	totalAddPeerAttempt         *prometheus.CounterVec
+	totalPeerConnected          *prometheus.CounterVec
+	totalPeerDisConnected       *prometheus.CounterVec
+	activeSessions              *prometheus.GaugeVec
+	activeConnections           *prometheus.GaugeVec
+	bufferedBytes               *prometheus.GaugeVec
+	dialDuration                *prometheus.HistogramVec
+	connectionDuration          *prometheus.HistogramVec
+	pauseDuration               *prometheus.HistogramVec
+	sessionRTT                  *prometheus.GaugeVec
+	peerUp                      *prometheus.GaugeVec
+	rateLimit                   *prometheus.GaugeVec
+	totalRateLimitedSeconds     *prometheus.CounterVec
+	totalRejectedConnections    *prometheus.CounterVec
+	memoryBudgetUsed            prometheus.Gauge
+	memoryBudgetLimit           prometheus.Gauge
+	totalMemoryBudgetEvictions  *prometheus.CounterVec
+	totalConnectionTimeouts     *prometheus.CounterVec
+	totalStaleConnections       *prometheus.CounterVec
+}
+
+func newCollectors() *collectors {
+	return &collectors{
+		totalAddWS: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_add_websocket_session",
+				Help:      "Total count of added websocket sessions",
+			},
+			[]string{"clientkey", "peer"}),
+
+		totalRemoveWS: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_remove_websocket_session",
+				Help:      "Total count of removed websocket sessions",
+			},
+			[]string{"clientkey", "peer"}),
+
+		totalAddConnectionsForWS: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_add_connections",
+				Help:      "Total count of added connections",
+			},
+			[]string{"clientkey", "proto", "addr"},
+		),
+
+		totalRemoveConnectionsForWS: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_remove_connections",
+				Help:      "Total count of removed connections",
+			},
+			[]string{"clientkey", "proto", "addr"},
+		),
+
+		totalTransmitBytesOnWS: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_transmit_bytes",
+				Help:      "Total bytes transmitted",
+			},
+			[]string{"clientkey"},
+		),
+
+		totalTransmitErrorBytesOnWS: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_transmit_error_bytes",
+				Help:      "Total error bytes transmitted",
+			},
+			[]string{"clientkey"},
+		),
+
+		totalReceiveBytesOnWS: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_receive_bytes",
+				Help:      "Total bytes received",
+			},
+			[]string{"clientkey"},
+		),
+
+		totalAddPeerAttempt: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_peer_ws_attempt",
+				Help:      "Total count of attempts to establish websocket session to other rancher-server",
+			},
+			[]string{"peer"},
+		),
+
+		totalPeerConnected: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_peer_ws_connected",
+				Help:      "Total count of connected websocket sessions to other rancher-server",
+			},
+			[]string{"peer"},
+		),
+
+		totalPeerDisConnected: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_peer_ws_disconnected",
+				Help:      "Total count of disconnected websocket sessions from other rancher-server",
+			},
+			[]string{"peer"},
+		),
+
+		activeSessions: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Subsystem: "session_server",
+				Name:      "active_websocket_sessions",
+				Help:      "Number of currently active websocket sessions",
+			},
+			[]string{"clientkey", "peer"},
+		),
+
+		activeConnections: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Subsystem: "session_server",
+				Name:      "active_connections",
+				Help:      "Number of currently active connections",
+			},
+			[]string{"clientkey"},
+		),
+
+		bufferedBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Subsystem: "session_server",
+				Name:      "buffered_bytes",
+				Help:      "Bytes received and waiting to be read",
+			},
+			[]string{"clientkey"},
+		),
+
+		dialDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Subsystem: "session_server",
+				Name:      "dial_duration_seconds",
+				Help:      "Time taken to dial the target of a connection",
+				Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+			},
+			[]string{"clientkey", "proto", "success"},
+		),
+
+		connectionDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Subsystem: "session_server",
+				Name:      "connection_duration_seconds",
+				Help:      "Lifetime of connections",
+				Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+			},
+			[]string{"clientkey", "proto"},
+		),
+
+		pauseDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Subsystem: "session_server",
+				Name:      "pause_duration_seconds",
+				Help:      "Time connections spent paused by the remote end",
+				Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+			},
+			[]string{"clientkey"},
+		),
+
+		sessionRTT: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Subsystem: "session_server",
+				Name:      "websocket_session_rtt_seconds",
+				Help:      "Last round trip time measured among the websocket sessions of the client key",
+			},
+			[]string{"clientkey"},
+		),
+
+		peerUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Subsystem: "session_server",
+				Name:      "peer_ws_up",
+				Help:      "Whether the websocket session to other rancher-server is currently established",
+			},
+			[]string{"peer"},
+		),
+
+		rateLimit: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Subsystem: "session_server",
+				Name:      "rate_limit_bytes_per_second",
+				Help:      "Bandwidth limit configured for a client, its sessions or its connections, 0 when unlimited",
+			},
+			[]string{"clientkey", "scope"},
+		),
+
+		totalRateLimitedSeconds: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_rate_limited_seconds",
+				Help:      "Total time transfers were delayed by bandwidth limits",
+			},
+			[]string{"clientkey", "direction"},
+		),
+
+		totalRejectedConnections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_rejected_connections",
+				Help:      "Total count of connections rejected by connection limits",
+			},
+			[]string{"clientkey", "limit"},
+		),
+
+		memoryBudgetUsed: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Subsystem: "session_server",
+				Name:      "memory_budget_used_bytes",
+				Help:      "Bytes buffered against the memory budget",
+			},
+		),
+
+		memoryBudgetLimit: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Subsystem: "session_server",
+				Name:      "memory_budget_limit_bytes",
+				Help:      "Maximum number of bytes that can be buffered against the memory budget",
+			},
+		),
+
+		totalMemoryBudgetEvictions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_memory_budget_evictions",
+				Help:      "Total count of connections closed to stay within the memory budget",
+			},
+			[]string{"clientkey"},
+		),
+
+		totalConnectionTimeouts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_connection_timeouts",
+				Help:      "Total count of connections closed for being idle or paused for too long",
+			},
+			[]string{"clientkey", "reason"},
+		),
+
+		totalStaleConnections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "session_server",
+				Name:      "total_stale_connections_closed",
+				Help:      "Total count of connections closed because the remote end no longer knew them",
+			},
+			[]string{"clientkey"},
+		),
+	}
+}
+
+// register registers all the collectors with the provided registerer
+func (c *collectors) register(registerer prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{
+		c.totalAddWS,
+		c.totalRemoveWS,
+		c.totalAddConnectionsForWS,
+		c.totalRemoveConnectionsForWS,
+		c.totalTransmitBytesOnWS,
+		c.totalTransmitErrorBytesOnWS,
+		c.totalReceiveBytesOnWS,
+		c.totalAddPeerAttempt,
+		c.totalPeerConnected,
+		c.totalPeerDisConnected,
+		c.activeSessions,
+		c.activeConnections,
+		c.bufferedBytes,
+		c.dialDuration,
+		c.connectionDuration,
+		c.pauseDuration,
+		c.sessionRTT,
+		c.peerUp,
+		c.rateLimit,
+		c.totalRateLimitedSeconds,
+		c.totalRejectedConnections,
+		c.memoryBudgetUsed,
+		c.memoryBudgetLimit,
+		c.totalMemoryBudgetEvictions,
+		c.totalConnectionTimeouts,
+		c.totalStaleConnections,
+	} {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
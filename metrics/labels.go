@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"net"
+	"sync"
+)
+
+// LabelPolicy controls the values used for the potentially unbounded "addr" and "clientkey" labels.
+// Every distinct value becomes a new series, so large deployments may need to drop or aggregate them.
+type LabelPolicy struct {
+	// Addr maps a dialed address to the value of the "addr" label. A nil function keeps the address as is.
+	Addr func(address string) string
+	// ClientKey maps a client key to the value of the "clientkey" label. A nil function keeps the client key as is.
+	ClientKey func(clientKey string) string
+}
+
+var (
+	labelPolicy     LabelPolicy
+	labelPolicyLock sync.RWMutex
+)
+
+// SetLabelPolicy replaces the label policy used by the package level metrics from now on
+func SetLabelPolicy(policy LabelPolicy) {
+	labelPolicyLock.Lock()
+	defer labelPolicyLock.Unlock()
+	labelPolicy = policy
+}
+
+// DropLabel collapses every value of a label into the empty string
+func DropLabel(string) string {
+	return ""
+}
+
+// AddrPort aggregates addresses by their port, so all the hosts dialed on the same port share a series
+func AddrPort(address string) string {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
+func (p LabelPolicy) addr(address string) string {
+	if p.Addr == nil {
+		return address
+	}
+	return p.Addr(address)
+}
+
+func (p LabelPolicy) clientKey(clientKey string) string {
+	if p.ClientKey == nil {
+		return clientKey
+	}
+	return p.ClientKey(clientKey)
+}
+
+func addrLabel(address string) string {
+	labelPolicyLock.RLock()
+	defer labelPolicyLock.RUnlock()
+	return labelPolicy.addr(address)
+}
+
+func clientKeyLabel(clientKey string) string {
+	labelPolicyLock.RLock()
+	defer labelPolicyLock.RUnlock()
+	return labelPolicy.clientKey(clientKey)
+}
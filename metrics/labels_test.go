@@ -0,0 +1,36 @@
+package metrics
+
+import "testing"
+
+func TestAddrPort(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		address, expected string
+	}{
+		{"127.0.0.1:6443", "6443"},
+		{"[::1]:10250", "10250"},
+		{"kubernetes.default.svc:443", "443"},
+		{"/var/run/docker.sock", ""},
+	}
+	for _, tt := range tests {
+		if got, want := AddrPort(tt.address), tt.expected; got != want {
+			t.Errorf("incorrect label for %q, got: %q, want: %q", tt.address, got, want)
+		}
+	}
+}
+
+func TestSetLabelPolicy(t *testing.T) {
+	defer SetLabelPolicy(LabelPolicy{})
+
+	if got, want := addrLabel("localhost:80"), "localhost:80"; got != want {
+		t.Errorf("incorrect default addr label, got: %q, want: %q", got, want)
+	}
+
+	SetLabelPolicy(LabelPolicy{Addr: AddrPort, ClientKey: DropLabel})
+	if got, want := addrLabel("localhost:80"), "80"; got != want {
+		t.Errorf("incorrect aggregated addr label, got: %q, want: %q", got, want)
+	}
+	if got, want := clientKeyLabel("c-12345"), ""; got != want {
+		t.Errorf("incorrect dropped clientkey label, got: %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,316 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Recorder records the metrics of a remotedialer Server or client session
+type Recorder interface {
+	SessionAdded(clientKey string, peer bool)
+	SessionRemoved(clientKey string, peer bool)
+	// SessionRTT is called with the round trip time measured by a session, client keys with several sessions reporting the last one measured
+	SessionRTT(clientKey string, rtt time.Duration)
+	ConnectionAdded(clientKey, proto, addr string)
+	ConnectionRemoved(clientKey, proto, addr string, lifetime time.Duration)
+	TransmitBytes(clientKey string, size int)
+	TransmitErrorBytes(clientKey string, size int)
+	ReceiveBytes(clientKey string, size int)
+	BufferedBytes(clientKey string, delta int)
+	Dial(clientKey, proto string, success bool, duration time.Duration)
+	Paused(clientKey string, duration time.Duration)
+	PeerAttempt(peer string)
+	PeerConnected(peer string)
+	PeerDisconnected(peer string)
+	// RateLimitSet is called when the bandwidth limit of a scope ("client", "session" or "connection") changes, 0 meaning unlimited
+	RateLimitSet(clientKey, scope string, bytesPerSecond float64)
+	// RateLimited is called when a transfer in the given direction ("in" or "out") is delayed by a bandwidth limit
+	RateLimited(clientKey, direction string, delay time.Duration)
+	// ConnectionRejected is called when a connection is rejected by a connection limit, such as "max connections per session"
+	ConnectionRejected(clientKey, limit string)
+	// MemoryBudgetUsage is called when the data buffered against a memory budget changes
+	MemoryBudgetUsage(used, limit int64)
+	// MemoryBudgetEviction is called when a connection is closed to stay within a memory budget
+	MemoryBudgetEviction(clientKey string)
+	// ConnectionTimedOut is called when a connection is closed for being idle or paused for too long, reason being "idle" or "paused"
+	ConnectionTimedOut(clientKey, reason string)
+	// StaleConnectionsClosed is called when connections no longer active on the remote end are closed after a connections sync
+	StaleConnectionsClosed(clientKey string, count int)
+}
+
+var (
+	// Default records the package level metrics, which are only collected after calling Register or MustRegister,
+	// or when the CATTLE_PROMETHEUS_METRICS environment variable is set to "true"
+	Default Recorder = defaultRecorder{}
+	// Noop discards all metrics
+	Noop Recorder = noopRecorder{}
+)
+
+// PrometheusRecorder is a Recorder with its own set of Prometheus collectors
+type PrometheusRecorder struct {
+	c      *collectors
+	policy LabelPolicy
+}
+
+// NewPrometheusRecorder creates a Recorder whose metrics are registered with the provided registerer,
+// using the provided policy for the "addr" and "clientkey" labels
+func NewPrometheusRecorder(registerer prometheus.Registerer, policy LabelPolicy) (*PrometheusRecorder, error) {
+	c := newCollectors()
+	if err := c.register(registerer); err != nil {
+		return nil, err
+	}
+	return &PrometheusRecorder{
+		c:      c,
+		policy: policy,
+	}, nil
+}
+
+func (p *PrometheusRecorder) SessionAdded(clientKey string, peer bool) {
+	labels := prometheus.Labels{
+		"clientkey": p.policy.clientKey(clientKey),
+		"peer":      strconv.FormatBool(peer),
+	}
+	p.c.totalAddWS.With(labels).Inc()
+	p.c.activeSessions.With(labels).Inc()
+}
+
+func (p *PrometheusRecorder) SessionRemoved(clientKey string, peer bool) {
+	labels := prometheus.Labels{
+		"clientkey": p.policy.clientKey(clientKey),
+		"peer":      strconv.FormatBool(peer),
+	}
+	p.c.totalRemoveWS.With(labels).Inc()
+	p.c.activeSessions.With(labels).Dec()
+}
+
+func (p *PrometheusRecorder) SessionRTT(clientKey string, rtt time.Duration) {
+	p.c.sessionRTT.With(prometheus.Labels{"clientkey": p.policy.clientKey(clientKey)}).Set(rtt.Seconds())
+}
+
+func (p *PrometheusRecorder) ConnectionAdded(clientKey, proto, addr string) {
+	clientKey = p.policy.clientKey(clientKey)
+	p.c.totalAddConnectionsForWS.With(prometheus.Labels{
+		"clientkey": clientKey,
+		"proto":     proto,
+		"addr":      p.policy.addr(addr),
+	}).Inc()
+	p.c.activeConnections.With(prometheus.Labels{"clientkey": clientKey}).Inc()
+}
+
+func (p *PrometheusRecorder) ConnectionRemoved(clientKey, proto, addr string, lifetime time.Duration) {
+	clientKey = p.policy.clientKey(clientKey)
+	p.c.totalRemoveConnectionsForWS.With(prometheus.Labels{
+		"clientkey": clientKey,
+		"proto":     proto,
+		"addr":      p.policy.addr(addr),
+	}).Inc()
+	p.c.activeConnections.With(prometheus.Labels{"clientkey": clientKey}).Dec()
+	p.c.connectionDuration.With(prometheus.Labels{
+		"clientkey": clientKey,
+		"proto":     proto,
+	}).Observe(lifetime.Seconds())
+}
+
+func (p *PrometheusRecorder) TransmitBytes(clientKey string, size int) {
+	p.c.totalTransmitBytesOnWS.With(prometheus.Labels{"clientkey": p.policy.clientKey(clientKey)}).Add(float64(size))
+}
+
+func (p *PrometheusRecorder) TransmitErrorBytes(clientKey string, size int) {
+	p.c.totalTransmitErrorBytesOnWS.With(prometheus.Labels{"clientkey": p.policy.clientKey(clientKey)}).Add(float64(size))
+}
+
+func (p *PrometheusRecorder) ReceiveBytes(clientKey string, size int) {
+	p.c.totalReceiveBytesOnWS.With(prometheus.Labels{"clientkey": p.policy.clientKey(clientKey)}).Add(float64(size))
+}
+
+func (p *PrometheusRecorder) BufferedBytes(clientKey string, delta int) {
+	p.c.bufferedBytes.With(prometheus.Labels{"clientkey": p.policy.clientKey(clientKey)}).Add(float64(delta))
+}
+
+func (p *PrometheusRecorder) Dial(clientKey, proto string, success bool, duration time.Duration) {
+	p.c.dialDuration.With(prometheus.Labels{
+		"clientkey": p.policy.clientKey(clientKey),
+		"proto":     proto,
+		"success":   strconv.FormatBool(success),
+	}).Observe(duration.Seconds())
+}
+
+func (p *PrometheusRecorder) Paused(clientKey string, duration time.Duration) {
+	p.c.pauseDuration.With(prometheus.Labels{"clientkey": p.policy.clientKey(clientKey)}).Observe(duration.Seconds())
+}
+
+func (p *PrometheusRecorder) PeerAttempt(peer string) {
+	p.c.totalAddPeerAttempt.With(prometheus.Labels{"peer": peer}).Inc()
+}
+
+func (p *PrometheusRecorder) PeerConnected(peer string) {
+	p.c.totalPeerConnected.With(prometheus.Labels{"peer": peer}).Inc()
+	p.c.peerUp.With(prometheus.Labels{"peer": peer}).Set(1)
+}
+
+func (p *PrometheusRecorder) PeerDisconnected(peer string) {
+	p.c.totalPeerDisConnected.With(prometheus.Labels{"peer": peer}).Inc()
+	p.c.peerUp.With(prometheus.Labels{"peer": peer}).Set(0)
+}
+
+func (p *PrometheusRecorder) RateLimitSet(clientKey, scope string, bytesPerSecond float64) {
+	p.c.rateLimit.With(prometheus.Labels{
+		"clientkey": p.policy.clientKey(clientKey),
+		"scope":     scope,
+	}).Set(bytesPerSecond)
+}
+
+func (p *PrometheusRecorder) RateLimited(clientKey, direction string, delay time.Duration) {
+	p.c.totalRateLimitedSeconds.With(prometheus.Labels{
+		"clientkey": p.policy.clientKey(clientKey),
+		"direction": direction,
+	}).Add(delay.Seconds())
+}
+
+func (p *PrometheusRecorder) ConnectionRejected(clientKey, limit string) {
+	p.c.totalRejectedConnections.With(prometheus.Labels{
+		"clientkey": p.policy.clientKey(clientKey),
+		"limit":     limit,
+	}).Inc()
+}
+
+func (p *PrometheusRecorder) MemoryBudgetUsage(used, limit int64) {
+	p.c.memoryBudgetUsed.Set(float64(used))
+	p.c.memoryBudgetLimit.Set(float64(limit))
+}
+
+func (p *PrometheusRecorder) MemoryBudgetEviction(clientKey string) {
+	p.c.totalMemoryBudgetEvictions.With(prometheus.Labels{"clientkey": p.policy.clientKey(clientKey)}).Inc()
+}
+
+func (p *PrometheusRecorder) ConnectionTimedOut(clientKey, reason string) {
+	p.c.totalConnectionTimeouts.With(prometheus.Labels{
+		"clientkey": p.policy.clientKey(clientKey),
+		"reason":    reason,
+	}).Inc()
+}
+
+func (p *PrometheusRecorder) StaleConnectionsClosed(clientKey string, count int) {
+	p.c.totalStaleConnections.With(prometheus.Labels{"clientkey": p.policy.clientKey(clientKey)}).Add(float64(count))
+}
+
+// defaultRecorder records the package level metrics
+type defaultRecorder struct{}
+
+func (defaultRecorder) SessionAdded(clientKey string, peer bool) {
+	IncSMTotalAddWS(clientKey, peer)
+	AddSMActiveSessions(clientKey, peer, 1)
+}
+
+func (defaultRecorder) SessionRemoved(clientKey string, peer bool) {
+	IncSMTotalRemoveWS(clientKey, peer)
+	AddSMActiveSessions(clientKey, peer, -1)
+}
+
+func (defaultRecorder) SessionRTT(clientKey string, rtt time.Duration) {
+	SetSMSessionRTT(clientKey, rtt)
+}
+
+func (defaultRecorder) ConnectionAdded(clientKey, proto, addr string) {
+	IncSMTotalAddConnectionsForWS(clientKey, proto, addr)
+	AddSMActiveConnections(clientKey, 1)
+}
+
+func (defaultRecorder) ConnectionRemoved(clientKey, proto, addr string, lifetime time.Duration) {
+	IncSMTotalRemoveConnectionsForWS(clientKey, proto, addr)
+	AddSMActiveConnections(clientKey, -1)
+	ObserveSMConnectionDuration(clientKey, proto, lifetime)
+}
+
+func (defaultRecorder) TransmitBytes(clientKey string, size int) {
+	AddSMTotalTransmitBytesOnWS(clientKey, float64(size))
+}
+
+func (defaultRecorder) TransmitErrorBytes(clientKey string, size int) {
+	AddSMTotalTransmitErrorBytesOnWS(clientKey, float64(size))
+}
+
+func (defaultRecorder) ReceiveBytes(clientKey string, size int) {
+	AddSMTotalReceiveBytesOnWS(clientKey, float64(size))
+}
+
+func (defaultRecorder) BufferedBytes(clientKey string, delta int) {
+	AddSMBufferedBytes(clientKey, float64(delta))
+}
+
+func (defaultRecorder) Dial(clientKey, proto string, success bool, duration time.Duration) {
+	ObserveSMDialDuration(clientKey, proto, success, duration)
+}
+
+func (defaultRecorder) Paused(clientKey string, duration time.Duration) {
+	ObserveSMPauseDuration(clientKey, duration)
+}
+
+func (defaultRecorder) PeerAttempt(peer string) {
+	IncSMTotalAddPeerAttempt(peer)
+}
+
+func (defaultRecorder) PeerConnected(peer string) {
+	IncSMTotalPeerConnected(peer)
+	SetSMPeerUp(peer, true)
+}
+
+func (defaultRecorder) PeerDisconnected(peer string) {
+	IncSMTotalPeerDisConnected(peer)
+	SetSMPeerUp(peer, false)
+}
+
+func (defaultRecorder) RateLimitSet(clientKey, scope string, bytesPerSecond float64) {
+	SetSMRateLimit(clientKey, scope, bytesPerSecond)
+}
+
+func (defaultRecorder) RateLimited(clientKey, direction string, delay time.Duration) {
+	AddSMTotalRateLimitedSeconds(clientKey, direction, delay)
+}
+
+func (defaultRecorder) ConnectionRejected(clientKey, limit string) {
+	IncSMTotalRejectedConnections(clientKey, limit)
+}
+
+func (defaultRecorder) MemoryBudgetUsage(used, limit int64) {
+	SetSMMemoryBudgetUsage(used, limit)
+}
+
+func (defaultRecorder) MemoryBudgetEviction(clientKey string) {
+	IncSMTotalMemoryBudgetEvictions(clientKey)
+}
+
+func (defaultRecorder) ConnectionTimedOut(clientKey, reason string) {
+	IncSMTotalConnectionTimeouts(clientKey, reason)
+}
+
+func (defaultRecorder) StaleConnectionsClosed(clientKey string, count int) {
+	AddSMTotalStaleConnections(clientKey, count)
+}
+
+// noopRecorder discards all metrics
+type noopRecorder struct{}
+
+func (noopRecorder) SessionAdded(string, bool)                               {}
+func (noopRecorder) SessionRemoved(string, bool)                             {}
+func (noopRecorder) SessionRTT(string, time.Duration)                        {}
+func (noopRecorder) ConnectionAdded(string, string, string)                  {}
+func (noopRecorder) ConnectionRemoved(string, string, string, time.Duration) {}
+func (noopRecorder) TransmitBytes(string, int)                               {}
+func (noopRecorder) TransmitErrorBytes(string, int)                          {}
+func (noopRecorder) ReceiveBytes(string, int)                                {}
+func (noopRecorder) BufferedBytes(string, int)                               {}
+func (noopRecorder) Dial(string, string, bool, time.Duration)                {}
+func (noopRecorder) Paused(string, time.Duration)                            {}
+func (noopRecorder) PeerAttempt(string)                                      {}
+func (noopRecorder) PeerConnected(string)                                    {}
+func (noopRecorder) PeerDisconnected(string)                                 {}
+func (noopRecorder) RateLimitSet(string, string, float64)                    {}
+func (noopRecorder) RateLimited(string, string, time.Duration)               {}
+func (noopRecorder) ConnectionRejected(string, string)                       {}
+func (noopRecorder) MemoryBudgetUsage(int64, int64)                          {}
+func (noopRecorder) MemoryBudgetEviction(string)                             {}
+func (noopRecorder) ConnectionTimedOut(string, string)                       {}
+func (noopRecorder) StaleConnectionsClosed(string, int)                      {}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrometheusRecorder(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+	recorder, err := NewPrometheusRecorder(registry, LabelPolicy{Addr: AddrPort})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.SessionAdded("c1", false)
+	recorder.ConnectionAdded("c1", "tcp", "10.0.0.1:443")
+	recorder.ConnectionAdded("c1", "tcp", "10.0.0.2:443")
+	recorder.ConnectionRemoved("c1", "tcp", "10.0.0.1:443", time.Second)
+
+	if got, want := testutil.ToFloat64(recorder.c.activeSessions.WithLabelValues("c1", "false")), 1.0; got != want {
+		t.Errorf("incorrect active sessions, got: %v, want: %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(recorder.c.activeConnections.WithLabelValues("c1")), 1.0; got != want {
+		t.Errorf("incorrect active connections, got: %v, want: %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(recorder.c.totalAddConnectionsForWS.WithLabelValues("c1", "tcp", "443")), 2.0; got != want {
+		t.Errorf("incorrect added connections for aggregated addr, got: %v, want: %v", got, want)
+	}
+
+	// a second recorder can be registered on a different registry without sharing any state
+	other, err := NewPrometheusRecorder(prometheus.NewRegistry(), LabelPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := testutil.ToFloat64(other.c.activeConnections.WithLabelValues("c1")), 0.0; got != want {
+		t.Errorf("recorders should not share metrics, got: %v, want: %v", got, want)
+	}
+
+	// registering twice on the same registry fails
+	if _, err := NewPrometheusRecorder(registry, LabelPolicy{}); err == nil {
+		t.Error("expected an error registering the same metrics twice")
+	}
+}
@@ -2,6 +2,8 @@ package metrics
 
 import (
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -10,92 +12,36 @@ const metricsEnv = "CATTLE_PROMETHEUS_METRICS"
 
 var prometheusMetrics = false
 
+// defaultCollectors are the collectors used by the package level functions, registered with Register or MustRegister
+var defaultCollectors = newCollectors()
+
 var (
-	TotalAddWS = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Subsystem: "session_server",
-			Name:      "total_add_websocket_session",
-			Help:      "Total count of added websocket sessions",
-		},
-		[]string{"clientkey", "peer"})
-
-	TotalRemoveWS = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Subsystem: "session_server",
-			Name:      "total_remove_websocket_session",
-			Help:      "Total count of removed websocket sessions",
-		},
-		[]string{"clientkey", "peer"})
-
-	TotalAddConnectionsForWS = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Subsystem: "session_server",
-			Name:      "total_add_connections",
-			Help:      "Total count of added connections",
-		},
-		[]string{"clientkey", "proto", "addr"},
-	)
-
-	TotalRemoveConnectionsForWS = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Subsystem: "session_server",
-			Name:      "total_remove_connections",
-			Help:      "Total count of removed connections",
-		},
-		[]string{"clientkey", "proto", "addr"},
-	)
-
-	TotalTransmitBytesOnWS = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Subsystem: "session_server",
-			Name:      "total_transmit_bytes",
-			Help:      "Total bytes transmitted",
-		},
-		[]string{"clientkey"},
-	)
-
-	TotalTransmitErrorBytesOnWS = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Subsystem: "session_server",
-			Name:      "total_transmit_error_bytes",
-			Help:      "Total error bytes transmitted",
-		},
-		[]string{"clientkey"},
-	)
-
-	TotalReceiveBytesOnWS = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Subsystem: "session_server",
-			Name:      "total_receive_bytes",
-			Help:      "Total bytes received",
-		},
-		[]string{"clientkey"},
-	)
-
-	TotalAddPeerAttempt = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Subsystem: "session_server",
-			Name:      "total_peer_ws_attempt",
-			Help:      "Total count of attempts to establish websocket session to other rancher-server",
-		},
-		[]string{"peer"},
-	)
-	TotalPeerConnected = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Subsystem: "session_server",
-			Name:      "total_peer_ws_connected",
-			Help:      "Total count of connected websocket sessions to other rancher-server",
-		},
-		[]string{"peer"},
-	)
-	TotalPeerDisConnected = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Subsystem: "session_server",
-			Name:      "total_peer_ws_disconnected",
-			Help:      "Total count of disconnected websocket sessions from other rancher-server",
-		},
-		[]string{"peer"},
-	)
+	TotalAddWS                  = defaultCollectors.totalAddWS
+	TotalRemoveWS               = defaultCollectors.totalRemoveWS
+	TotalAddConnectionsForWS    = defaultCollectors.totalAddConnectionsForWS
+	TotalRemoveConnectionsForWS = defaultCollectors.totalRemoveConnectionsForWS
+	TotalTransmitBytesOnWS      = defaultCollectors.totalTransmitBytesOnWS
+	TotalTransmitErrorBytesOnWS = defaultCollectors.totalTransmitErrorBytesOnWS
+	TotalReceiveBytesOnWS       = defaultCollectors.totalReceiveBytesOnWS
+	TotalAddPeerAttempt         = defaultCollectors.totalAddPeerAttempt
+	TotalPeerConnected          = defaultCollectors.totalPeerConnected
+	TotalPeerDisConnected       = defaultCollectors.totalPeerDisConnected
+	ActiveSessions              = defaultCollectors.activeSessions
+	ActiveConnections           = defaultCollectors.activeConnections
+	BufferedBytes               = defaultCollectors.bufferedBytes
+	DialDuration                = defaultCollectors.dialDuration
+	ConnectionDuration          = defaultCollectors.connectionDuration
+	PauseDuration               = defaultCollectors.pauseDuration
+	SessionRTT                  = defaultCollectors.sessionRTT
+	PeerUp                      = defaultCollectors.peerUp
+	RateLimit                   = defaultCollectors.rateLimit
+	TotalRateLimitedSeconds     = defaultCollectors.totalRateLimitedSeconds
+	TotalRejectedConnections    = defaultCollectors.totalRejectedConnections
+	MemoryBudgetUsed            = defaultCollectors.memoryBudgetUsed
+	MemoryBudgetLimit           = defaultCollectors.memoryBudgetLimit
+	TotalMemoryBudgetEvictions  = defaultCollectors.totalMemoryBudgetEvictions
+	TotalConnectionTimeouts     = defaultCollectors.totalConnectionTimeouts
+	TotalStaleConnections       = defaultCollectors.totalStaleConnections
 )
 
 // Register registers a series of session
@@ -119,6 +65,22 @@ func MustRegister(registerer prometheus.Registerer) {
 	registerer.MustRegister(TotalAddPeerAttempt)
 	registerer.MustRegister(TotalPeerConnected)
 	registerer.MustRegister(TotalPeerDisConnected)
+	registerer.MustRegister(ActiveSessions)
+	registerer.MustRegister(ActiveConnections)
+	registerer.MustRegister(BufferedBytes)
+	registerer.MustRegister(DialDuration)
+	registerer.MustRegister(ConnectionDuration)
+	registerer.MustRegister(PauseDuration)
+	registerer.MustRegister(SessionRTT)
+	registerer.MustRegister(PeerUp)
+	registerer.MustRegister(RateLimit)
+	registerer.MustRegister(TotalRateLimitedSeconds)
+	registerer.MustRegister(TotalRejectedConnections)
+	registerer.MustRegister(MemoryBudgetUsed)
+	registerer.MustRegister(MemoryBudgetLimit)
+	registerer.MustRegister(TotalMemoryBudgetEvictions)
+	registerer.MustRegister(TotalConnectionTimeouts)
+	registerer.MustRegister(TotalStaleConnections)
 }
 
 func init() {
@@ -137,7 +99,7 @@ func IncSMTotalAddWS(clientKey string, peer bool) {
 	if prometheusMetrics {
 		TotalAddWS.With(
 			prometheus.Labels{
-				"clientkey": clientKey,
+				"clientkey": clientKeyLabel(clientKey),
 				"peer":      peerStr,
 			}).Inc()
 	}
@@ -153,7 +115,7 @@ func IncSMTotalRemoveWS(clientKey string, peer bool) {
 		}
 		TotalRemoveWS.With(
 			prometheus.Labels{
-				"clientkey": clientKey,
+				"clientkey": clientKeyLabel(clientKey),
 				"peer":      peerStr,
 			}).Inc()
 	}
@@ -163,7 +125,7 @@ func AddSMTotalTransmitErrorBytesOnWS(clientKey string, size float64) {
 	if prometheusMetrics {
 		TotalTransmitErrorBytesOnWS.With(
 			prometheus.Labels{
-				"clientkey": clientKey,
+				"clientkey": clientKeyLabel(clientKey),
 			}).Add(size)
 	}
 }
@@ -172,7 +134,7 @@ func AddSMTotalTransmitBytesOnWS(clientKey string, size float64) {
 	if prometheusMetrics {
 		TotalTransmitBytesOnWS.With(
 			prometheus.Labels{
-				"clientkey": clientKey,
+				"clientkey": clientKeyLabel(clientKey),
 			}).Add(size)
 	}
 }
@@ -181,7 +143,7 @@ func AddSMTotalReceiveBytesOnWS(clientKey string, size float64) {
 	if prometheusMetrics {
 		TotalReceiveBytesOnWS.With(
 			prometheus.Labels{
-				"clientkey": clientKey,
+				"clientkey": clientKeyLabel(clientKey),
 			}).Add(size)
 	}
 }
@@ -190,9 +152,9 @@ func IncSMTotalAddConnectionsForWS(clientKey, proto, addr string) {
 	if prometheusMetrics {
 		TotalAddConnectionsForWS.With(
 			prometheus.Labels{
-				"clientkey": clientKey,
+				"clientkey": clientKeyLabel(clientKey),
 				"proto":     proto,
-				"addr":      addr,
+				"addr":      addrLabel(addr),
 			}).Inc()
 	}
 }
@@ -201,9 +163,9 @@ func IncSMTotalRemoveConnectionsForWS(clientKey, proto, addr string) {
 	if prometheusMetrics {
 		TotalRemoveConnectionsForWS.With(
 			prometheus.Labels{
-				"clientkey": clientKey,
+				"clientkey": clientKeyLabel(clientKey),
 				"proto":     proto,
-				"addr":      addr,
+				"addr":      addrLabel(addr),
 			}).Inc()
 	}
 }
@@ -235,3 +197,148 @@ func IncSMTotalPeerDisConnected(peer string) {
 
 	}
 }
+
+func AddSMActiveSessions(clientKey string, peer bool, delta float64) {
+	if prometheusMetrics {
+		ActiveSessions.With(
+			prometheus.Labels{
+				"clientkey": clientKeyLabel(clientKey),
+				"peer":      strconv.FormatBool(peer),
+			}).Add(delta)
+	}
+}
+
+func AddSMActiveConnections(clientKey string, delta float64) {
+	if prometheusMetrics {
+		ActiveConnections.With(
+			prometheus.Labels{
+				"clientkey": clientKeyLabel(clientKey),
+			}).Add(delta)
+	}
+}
+
+func AddSMBufferedBytes(clientKey string, delta float64) {
+	if prometheusMetrics {
+		BufferedBytes.With(
+			prometheus.Labels{
+				"clientkey": clientKeyLabel(clientKey),
+			}).Add(delta)
+	}
+}
+
+func ObserveSMDialDuration(clientKey, proto string, success bool, d time.Duration) {
+	if prometheusMetrics {
+		DialDuration.With(
+			prometheus.Labels{
+				"clientkey": clientKeyLabel(clientKey),
+				"proto":     proto,
+				"success":   strconv.FormatBool(success),
+			}).Observe(d.Seconds())
+	}
+}
+
+func ObserveSMConnectionDuration(clientKey, proto string, d time.Duration) {
+	if prometheusMetrics {
+		ConnectionDuration.With(
+			prometheus.Labels{
+				"clientkey": clientKeyLabel(clientKey),
+				"proto":     proto,
+			}).Observe(d.Seconds())
+	}
+}
+
+func ObserveSMPauseDuration(clientKey string, d time.Duration) {
+	if prometheusMetrics {
+		PauseDuration.With(
+			prometheus.Labels{
+				"clientkey": clientKeyLabel(clientKey),
+			}).Observe(d.Seconds())
+	}
+}
+
+func SetSMSessionRTT(clientKey string, rtt time.Duration) {
+	if prometheusMetrics {
+		SessionRTT.With(
+			prometheus.Labels{
+				"clientkey": clientKeyLabel(clientKey),
+			}).Set(rtt.Seconds())
+	}
+}
+
+func SetSMPeerUp(peer string, up bool) {
+	if prometheusMetrics {
+		var v float64
+		if up {
+			v = 1
+		}
+		PeerUp.With(
+			prometheus.Labels{
+				"peer": peer,
+			}).Set(v)
+	}
+}
+
+func SetSMRateLimit(clientKey, scope string, bytesPerSecond float64) {
+	if prometheusMetrics {
+		RateLimit.With(
+			prometheus.Labels{
+				"clientkey": clientKeyLabel(clientKey),
+				"scope":     scope,
+			}).Set(bytesPerSecond)
+	}
+}
+
+func AddSMTotalRateLimitedSeconds(clientKey, direction string, d time.Duration) {
+	if prometheusMetrics {
+		TotalRateLimitedSeconds.With(
+			prometheus.Labels{
+				"clientkey": clientKeyLabel(clientKey),
+				"direction": direction,
+			}).Add(d.Seconds())
+	}
+}
+
+func IncSMTotalRejectedConnections(clientKey, limit string) {
+	if prometheusMetrics {
+		TotalRejectedConnections.With(
+			prometheus.Labels{
+				"clientkey": clientKeyLabel(clientKey),
+				"limit":     limit,
+			}).Inc()
+	}
+}
+
+func SetSMMemoryBudgetUsage(used, limit int64) {
+	if prometheusMetrics {
+		MemoryBudgetUsed.Set(float64(used))
+		MemoryBudgetLimit.Set(float64(limit))
+	}
+}
+
+func IncSMTotalMemoryBudgetEvictions(clientKey string) {
+	if prometheusMetrics {
+		TotalMemoryBudgetEvictions.With(
+			prometheus.Labels{
+				"clientkey": clientKeyLabel(clientKey),
+			}).Inc()
+	}
+}
+
+func IncSMTotalConnectionTimeouts(clientKey, reason string) {
+	if prometheusMetrics {
+		TotalConnectionTimeouts.With(
+			prometheus.Labels{
+				"clientkey": clientKeyLabel(clientKey),
+				"reason":    reason,
+			}).Inc()
+	}
+}
+
+func AddSMTotalStaleConnections(clientKey string, count int) {
+	if prometheusMetrics {
+		TotalStaleConnections.With(
+			prometheus.Labels{
+				"clientkey": clientKeyLabel(clientKey),
+			}).Add(float64(count))
+	}
+}
@@ -0,0 +1,84 @@
+package remotedialer
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans created by this package
+const instrumentationName = "github.com/rancher/remotedialer"
+
+// Span names
+const (
+	// SpanDial covers a dial through a Session, until the Connect message is sent to the remote end
+	SpanDial = "remotedialer.dial"
+	// SpanRemoteDial covers the dial performed by the remote end on behalf of the dialing side
+	SpanRemoteDial = "remotedialer.remote_dial"
+	// SpanConnection covers the lifetime of a tunneled connection, on both ends
+	SpanConnection = "remotedialer.connection"
+)
+
+func otelTracerOrDefault(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(instrumentationName)
+}
+
+func connectionAttributes(c *connection) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("remotedialer.client_key", c.session.clientKey),
+		attribute.Int64("remotedialer.session_key", c.session.sessionKey),
+		attribute.Int64("remotedialer.conn_id", c.connID),
+		attribute.String("network.transport", c.addr.proto),
+		attribute.String("remotedialer.address", c.addr.address),
+	}
+}
+
+// startConnectionSpan starts the span covering the lifetime of c, which is ended when the connection is closed
+func (s *Session) startConnectionSpan(ctx context.Context, c *connection, kind trace.SpanKind) context.Context {
+	ctx, c.span = s.otelTracer.Start(ctx, SpanConnection, trace.WithSpanKind(kind), trace.WithAttributes(connectionAttributes(c)...))
+	return ctx
+}
+
+// injectTraceContext returns the trace context to be sent in a Connect message, or nil if propagation is disabled
+func (s *Session) injectTraceContext(ctx context.Context) map[string]string {
+	if s.propagator == nil {
+		return nil
+	}
+	carrier := propagation.MapCarrier{}
+	s.propagator.Inject(ctx, carrier)
+	return carrier
+}
+
+// extractTraceContext adds to ctx the trace context received in a Connect message.
+// W3C Trace Context is used when no propagator is configured, so incoming traces are always continued.
+func (s *Session) extractTraceContext(ctx context.Context, m *message) context.Context {
+	if len(m.traceContext) == 0 {
+		return ctx
+	}
+	propagator := s.propagator
+	if propagator == nil {
+		propagator = propagation.TraceContext{}
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(m.traceContext))
+}
+
+// endSpan ends span, recording err unless it signals a graceful termination
+func endSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
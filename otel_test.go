@@ -0,0 +1,113 @@
+package remotedialer
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceContextPropagation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+
+	dialed := make(chan trace.SpanContext, 1)
+	opts := ClientOptions{
+		TracerProvider: provider,
+		LocalDialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed <- trace.SpanContextFromContext(ctx)
+			client, server := net.Pipe()
+			go server.Close()
+			return client, nil
+		},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, func(server *Server) {
+		server.TracerProvider = provider
+		server.Propagator = propagation.TraceContext{}
+	})
+
+	dialCtx, parent := provider.Tracer("test").Start(ctx, "request")
+	conn, err := server.Dialer("client")(dialCtx, "tcp", "downstream:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	var remote trace.SpanContext
+	select {
+	case remote = <-dialed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("local dialer was not called")
+	}
+	if got, want := remote.TraceID(), parent.SpanContext().TraceID(); got != want {
+		t.Errorf("local dialer received the wrong trace, got: %s, want: %s", got, want)
+	}
+	if !remote.IsValid() || remote.SpanID() == parent.SpanContext().SpanID() {
+		t.Errorf("local dialer should receive the remote dial span, got: %v", remote)
+	}
+	conn.Close()
+
+	want := map[string]int{SpanDial: 1, SpanRemoteDial: 1, SpanConnection: 2}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := map[string]int{}
+		for _, span := range exporter.GetSpans() {
+			if span.SpanContext.TraceID() != parent.SpanContext().TraceID() {
+				t.Errorf("span %s belongs to another trace", span.Name)
+			}
+			got[span.Name]++
+		}
+		if got[SpanDial] == want[SpanDial] && got[SpanRemoteDial] == want[SpanRemoteDial] && got[SpanConnection] == want[SpanConnection] {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected spans, got: %v, want: %v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTraceContextNotPropagatedByDefault(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := sdktrace.NewTracerProvider()
+	defer provider.Shutdown(context.Background())
+
+	dialed := make(chan trace.SpanContext, 1)
+	opts := ClientOptions{
+		LocalDialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed <- trace.SpanContextFromContext(ctx)
+			client, server := net.Pipe()
+			go server.Close()
+			return client, nil
+		},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, nil)
+
+	dialCtx, parent := provider.Tracer("test").Start(ctx, "request")
+	defer parent.End()
+	conn, err := server.Dialer("client")(dialCtx, "tcp", "downstream:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case remote := <-dialed:
+		if remote.TraceID() == parent.SpanContext().TraceID() {
+			t.Error("trace context should not be sent without a Propagator")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("local dialer was not called")
+	}
+}
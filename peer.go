@@ -10,8 +10,6 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/rancher/remotedialer/metrics"
-	"github.com/sirupsen/logrus"
 )
 
 var (
@@ -32,7 +30,7 @@ func (s *Server) AddPeer(url, id, token string) {
 		cancel: cancel,
 	}
 
-	logrus.Infof("Adding peer %s, %s", url, id)
+	s.logger().Info("Adding peer", "url", url, LogKeyPeer, id)
 
 	s.peerLock.Lock()
 	defer s.peerLock.Unlock()
@@ -53,7 +51,7 @@ func (s *Server) RemovePeer(id string) {
 	defer s.peerLock.Unlock()
 
 	if p, ok := s.peers[id]; ok {
-		logrus.Infof("Removing peer %s", id)
+		s.logger().Info("Removing peer", LogKeyPeer, id)
 		p.cancel()
 	}
 	delete(s.peers, id)
@@ -83,6 +81,7 @@ func (p *peer) start(ctx context.Context, s *Server) {
 		HandshakeTimeout: HandshakeTimeOut,
 	}
 	ctx = context.WithValue(ctx, ContextKeyCaller, fmt.Sprintf("Peer url:%s, id:%s", p.url, p.id))
+	logger := s.logger().With(LogKeyPeer, p.id, "url", p.url)
 
 outer:
 	for {
@@ -92,32 +91,44 @@ outer:
 		default:
 		}
 
-		metrics.IncSMTotalAddPeerAttempt(p.id)
-		ws, _, err := dialer.Dial(p.url, headers)
+		recorder := metricsOrDefault(s.Metrics)
+		recorder.PeerAttempt(p.id)
+		ws, resp, err := dialer.Dial(p.url, withFeatures(headers))
 		if err != nil {
-			logrus.Errorf("Failed to connect to peer %s [local ID=%s]: %v", p.url, s.PeerID, err)
+			logger.Error("Failed to connect to peer", "localID", s.PeerID, "error", err)
 			time.Sleep(5 * time.Second)
 			continue
 		}
-		metrics.IncSMTotalPeerConnected(p.id)
-
-		session := NewClientSession(func(string, string) bool { return true }, ws)
+		recorder.PeerConnected(p.id)
+
+		session := NewClientSessionWithOptions(func(string, string) bool { return true }, ws, ClientOptions{
+			Metrics:           recorder,
+			Logger:            logger,
+			Tracer:            s.Tracer,
+			TracerProvider:    s.TracerProvider,
+			Propagator:        s.Propagator,
+			AuditSink:         s.AuditSink,
+			MemoryBudget:      s.MemoryBudget,
+			IdleTimeout:       s.IdleTimeout,
+			MaxPausedDuration: s.MaxPausedDuration,
+		})
+		session.connectExtension = hasFeature(resp.Header, featureConnectExtension)
 		session.dialer = func(ctx context.Context, network, address string) (net.Conn, error) {
 			parts := strings.SplitN(network, "::", 2)
 			if len(parts) != 2 {
 				return nil, fmt.Errorf("invalid clientKey/proto: %s", network)
 			}
-			d := s.Dialer(parts[0])
-			return d(ctx, parts[1], address)
+			return s.dial(ctx, DialInfo{ClientKey: parts[0], Network: parts[1], Address: address, PeerID: p.id})
 		}
 
 		s.sessions.addListener(session)
 		_, err = session.Serve(ctx)
 		s.sessions.removeListener(session)
 		session.Close()
+		recorder.PeerDisconnected(p.id)
 
 		if err != nil {
-			logrus.Errorf("Failed to serve peer connection %s: %v", p.id, err)
+			logger.Error("Failed to serve peer connection", "error", err)
 		}
 
 		ws.Close()
@@ -0,0 +1,190 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rancher/remotedialer"
+)
+
+// Options holds the optional settings of an Engine
+type Options struct {
+	// Logger receives the decision logs: denied connections at info level, allowed connections at debug level.
+	// slog.Default is used if nil.
+	Logger *slog.Logger
+	// Resolver resolves hostnames for the DenyResolvedCIDRs check. net.DefaultResolver is used if nil.
+	Resolver *net.Resolver
+}
+
+// Engine evaluates connections against a Policy, which can be replaced at any time without restarting the client
+type Engine struct {
+	policy   atomic.Pointer[compiledPolicy]
+	logger   *slog.Logger
+	resolver *net.Resolver
+}
+
+// NewEngine creates an Engine enforcing the given Policy
+func NewEngine(p *Policy, opts Options) (*Engine, error) {
+	e := &Engine{
+		logger:   opts.Logger,
+		resolver: opts.Resolver,
+	}
+	if e.logger == nil {
+		e.logger = slog.Default()
+	}
+	if e.resolver == nil {
+		e.resolver = net.DefaultResolver
+	}
+	if err := e.Update(p); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Update replaces the enforced Policy. The current Policy is kept if the new one is invalid.
+func (e *Engine) Update(p *Policy) error {
+	compiled, err := compile(p)
+	if err != nil {
+		return err
+	}
+	e.policy.Store(compiled)
+	return nil
+}
+
+// Evaluate decides whether a connection to the given address is allowed, logging the decision
+func (e *Engine) Evaluate(proto, address string) Decision {
+	decision := e.policy.Load().evaluate(proto, address)
+	e.logDecision(decision, proto, address)
+	return decision
+}
+
+func (e *Engine) logDecision(decision Decision, proto, address string, extra ...any) {
+	attrs := append([]any{"proto", proto, "address", address, "rule", decision.Rule}, extra...)
+	if decision.Allowed {
+		e.logger.Debug("Connection allowed by policy", attrs...)
+	} else {
+		e.logger.Info("Connection denied by policy", attrs...)
+	}
+}
+
+// Authorizer returns a ConnectAuthorizer enforcing the current Policy of the Engine
+func (e *Engine) Authorizer() remotedialer.ConnectAuthorizer {
+	return func(proto, address string) bool {
+		return e.Evaluate(proto, address).Allowed
+	}
+}
+
+// DialAuthorizer returns a DialAuthorizer enforcing the current Policy of the Engine, logging the dial metadata with every decision
+func (e *Engine) DialAuthorizer() remotedialer.DialAuthorizer {
+	return func(req remotedialer.DialRequest) bool {
+		decision := e.policy.Load().evaluate(req.Proto, req.Address)
+		e.logDecision(decision, req.Proto, req.Address, "metadata", req.Metadata)
+		return decision.Allowed
+	}
+}
+
+// Dialer returns a Dialer checking the IPs resolved for the dialed hostname against the DenyResolvedCIDRs of the
+// current Policy, before dialing the checked IPs with next, so the hostname is never resolved again.
+// A default net.Dialer is used if next is nil.
+func (e *Engine) Dialer(next remotedialer.Dialer) remotedialer.Dialer {
+	if next == nil {
+		d := &net.Dialer{}
+		next = d.DialContext
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		denied := e.policy.Load().denyResolved
+		if len(denied) == 0 || isUnix(network) {
+			return next(ctx, network, address)
+		}
+
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := e.resolve(ctx, network, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if containsIP(denied, ip) {
+				e.logDecision(Decision{Rule: "denyResolvedCIDRs"}, network, address, "ip", ip.String())
+				return nil, fmt.Errorf("connection to %s denied by policy: %s resolves to a denied network", address, ip)
+			}
+		}
+
+		var lastErr error
+		for _, ip := range ips {
+			conn, err := next(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+func (e *Engine) resolve(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{ip}, nil
+	}
+
+	ipNetwork := "ip"
+	switch network {
+	case "tcp4", "udp4":
+		ipNetwork = "ip4"
+	case "tcp6", "udp6":
+		ipNetwork = "ip6"
+	}
+	ips, err := e.resolver.LookupNetIP(ctx, ipNetwork, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+	return ips, nil
+}
+
+// Watch reloads the Policy stored at the given path whenever its content changes, until ctx is done.
+// Invalid policies are logged and ignored, keeping the last valid one in place.
+func (e *Engine) Watch(ctx context.Context, filename string, interval time.Duration) {
+	last, _ := os.ReadFile(filename)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			e.logger.Error("Failed to read policy", "file", filename, "error", err)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+
+		p, err := Parse(data)
+		if err == nil {
+			err = e.Update(p)
+		}
+		if err != nil {
+			e.logger.Error("Invalid policy, keeping the previous one", "file", filename, "error", err)
+			continue
+		}
+		e.logger.Info("Policy reloaded", "file", filename)
+	}
+}
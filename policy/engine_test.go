@@ -0,0 +1,89 @@
+package policy
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEngine_Dialer(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(p, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dialed string
+	dialer := e.Dialer(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = address
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	})
+
+	// an allowed hostname pointed at a denied network must not be dialed
+	if _, err := dialer(context.Background(), "tcp", "localhost:443"); err == nil {
+		t.Error("expected dialing a hostname resolving to a denied network to fail")
+	}
+	if dialed != "" {
+		t.Errorf("denied address was dialed: %s", dialed)
+	}
+
+	conn, err := dialer(context.Background(), "tcp", "10.42.1.5:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if dialed != "10.42.1.5:8080" {
+		t.Errorf("unexpected dialed address: %s", dialed)
+	}
+}
+
+func TestEngine_Watch(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(filename, []byte("default: deny"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(p, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := e.Authorizer()
+	if auth("tcp", "example.com:443") {
+		t.Fatal("expected connection to be denied")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Watch(ctx, filename, 10*time.Millisecond)
+
+	// invalid policies are ignored
+	if err := os.WriteFile(filename, []byte("default: maybe"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if auth("tcp", "example.com:443") {
+		t.Fatal("expected connection to be denied after loading an invalid policy")
+	}
+
+	if err := os.WriteFile(filename, []byte("default: allow"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !auth("tcp", "example.com:443") {
+		if time.Now().After(deadline) {
+			t.Fatal("policy was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,314 @@
+// Package policy implements declarative rules deciding which connections a remotedialer client may open
+// on behalf of the server.
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Action is the outcome of a rule
+type Action string
+
+const (
+	Allow Action = "allow"
+	Deny  Action = "deny"
+)
+
+// Policy is a list of rules evaluated in order, the first rule matching a connection decides whether it's allowed.
+// It can be loaded from YAML or JSON, for example:
+//
+//	default: deny
+//	rules:
+//	- name: kube-apiserver
+//	  action: allow
+//	  protos: [tcp]
+//	  hosts: ["*.svc.cluster.local", "kubernetes.default"]
+//	  ports: ["443", "6443"]
+//	- name: docker
+//	  action: allow
+//	  protos: [unix]
+//	  paths: [/var/run/docker.sock]
+//	denyResolvedCIDRs: [127.0.0.0/8, 169.254.0.0/16]
+type Policy struct {
+	// Default is the action taken when no rule matches. Connections are denied if empty.
+	Default Action `json:"default,omitempty" yaml:"default,omitempty"`
+	Rules   []Rule `json:"rules,omitempty" yaml:"rules,omitempty"`
+	// DenyResolvedCIDRs lists the networks that must never be dialed, checked against the IPs a hostname resolves to.
+	// It prevents allowed hostnames from being pointed at internal addresses, such as with DNS rebinding.
+	// It's only enforced by the Dialer returned by Engine.Dialer.
+	DenyResolvedCIDRs []string `json:"denyResolvedCIDRs,omitempty" yaml:"denyResolvedCIDRs,omitempty"`
+}
+
+// Rule matches a connection when every one of its non-empty criteria matches
+type Rule struct {
+	// Name identifies the rule in the decision logs
+	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
+	Action Action `json:"action" yaml:"action"`
+	// Protos lists the networks matched, such as "tcp" or "unix"
+	Protos []string `json:"protos,omitempty" yaml:"protos,omitempty"`
+	// CIDRs lists the networks matched by IP addresses. Hostnames never match, see Policy.DenyResolvedCIDRs.
+	CIDRs []string `json:"cidrs,omitempty" yaml:"cidrs,omitempty"`
+	// Hosts lists the hostnames or IP addresses matched, as case-insensitive patterns using the path.Match syntax.
+	// A trailing dot is ignored, so fully qualified hostnames match the same rules.
+	Hosts []string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	// Ports lists the ports matched, either a single port ("443") or an inclusive range ("8000-8999")
+	Ports []string `json:"ports,omitempty" yaml:"ports,omitempty"`
+	// Paths lists the unix socket paths matched, as patterns using the path.Match syntax
+	Paths []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+}
+
+// Parse decodes a Policy from YAML or JSON. Unknown fields are rejected, so a misspelled setting is never ignored.
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing policy: %w", err)
+	}
+	if _, err := compile(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// LoadFile reads and parses the Policy stored at the given path
+func LoadFile(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Decision is the result of evaluating a connection
+type Decision struct {
+	Allowed bool
+	// Rule is the name of the matching rule, or its position if unnamed. It's empty when the default action was used.
+	Rule string
+}
+
+type compiledPolicy struct {
+	defaultAllow bool
+	rules        []compiledRule
+	denyResolved []netip.Prefix
+}
+
+type compiledRule struct {
+	name   string
+	allow  bool
+	protos []string
+	cidrs  []netip.Prefix
+	hosts  []string
+	ports  []portRange
+	paths  []string
+}
+
+type portRange struct {
+	from, to int
+}
+
+func compile(p *Policy) (*compiledPolicy, error) {
+	c := &compiledPolicy{}
+	switch p.Default {
+	case Allow:
+		c.defaultAllow = true
+	case Deny, "":
+	default:
+		return nil, fmt.Errorf("invalid default action %q", p.Default)
+	}
+
+	for i, rule := range p.Rules {
+		name := rule.Name
+		if name == "" {
+			name = "#" + strconv.Itoa(i)
+		}
+		compiled, err := compileRule(name, rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", name, err)
+		}
+		c.rules = append(c.rules, compiled)
+	}
+
+	var err error
+	if c.denyResolved, err = parsePrefixes(p.DenyResolvedCIDRs); err != nil {
+		return nil, fmt.Errorf("denyResolvedCIDRs: %w", err)
+	}
+	return c, nil
+}
+
+func compileRule(name string, rule Rule) (compiledRule, error) {
+	c := compiledRule{
+		name:   name,
+		protos: rule.Protos,
+		paths:  rule.Paths,
+	}
+	switch rule.Action {
+	case Allow:
+		c.allow = true
+	case Deny:
+	default:
+		return c, fmt.Errorf("invalid action %q", rule.Action)
+	}
+
+	var err error
+	if c.cidrs, err = parsePrefixes(rule.CIDRs); err != nil {
+		return c, err
+	}
+	for _, host := range rule.Hosts {
+		host = normalizeHost(host)
+		if _, err := path.Match(host, ""); err != nil {
+			return c, fmt.Errorf("invalid host pattern %q: %w", host, err)
+		}
+		c.hosts = append(c.hosts, host)
+	}
+	for _, p := range rule.Paths {
+		if _, err := path.Match(p, ""); err != nil {
+			return c, fmt.Errorf("invalid path pattern %q: %w", p, err)
+		}
+	}
+	for _, ports := range rule.Ports {
+		r, err := parsePortRange(ports)
+		if err != nil {
+			return c, err
+		}
+		c.ports = append(c.ports, r)
+	}
+	if len(c.paths) > 0 && (len(c.cidrs) > 0 || len(c.hosts) > 0 || len(c.ports) > 0) {
+		return c, errors.New("paths can't be combined with cidrs, hosts or ports")
+	}
+	return c, nil
+}
+
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func parsePortRange(ports string) (portRange, error) {
+	from, to, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		to = from
+	}
+	r := portRange{}
+	var err error
+	if r.from, err = strconv.Atoi(from); err != nil || r.from < 0 || r.from > 65535 {
+		return r, fmt.Errorf("invalid port range %q", ports)
+	}
+	if r.to, err = strconv.Atoi(to); err != nil || r.to < r.from || r.to > 65535 {
+		return r, fmt.Errorf("invalid port range %q", ports)
+	}
+	return r, nil
+}
+
+func (c *compiledPolicy) evaluate(proto, address string) Decision {
+	for _, rule := range c.rules {
+		if rule.matches(proto, address) {
+			return Decision{Allowed: rule.allow, Rule: rule.name}
+		}
+	}
+	return Decision{Allowed: c.defaultAllow}
+}
+
+func (r *compiledRule) matches(proto, address string) bool {
+	if len(r.protos) > 0 && !contains(r.protos, proto) {
+		return false
+	}
+
+	if isUnix(proto) {
+		if len(r.cidrs) > 0 || len(r.hosts) > 0 || len(r.ports) > 0 {
+			return false
+		}
+		return len(r.paths) == 0 || matchAny(r.paths, path.Clean(address))
+	}
+	if len(r.paths) > 0 {
+		return false
+	}
+	if len(r.cidrs) == 0 && len(r.hosts) == 0 && len(r.ports) == 0 {
+		return true
+	}
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if len(r.hosts) > 0 && !matchAny(r.hosts, normalizeHost(host)) {
+		return false
+	}
+	if len(r.cidrs) > 0 {
+		ip, err := netip.ParseAddr(host)
+		if err != nil || !containsIP(r.cidrs, ip) {
+			return false
+		}
+	}
+	if len(r.ports) > 0 {
+		port, err := strconv.Atoi(portStr)
+		if err != nil || !inRanges(r.ports, port) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeHost returns the form of a hostname compared with the host patterns, "Example.com." matching "example.com"
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func isUnix(proto string) bool {
+	return proto == "unix" || proto == "unixgram" || proto == "unixpacket"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(prefixes []netip.Prefix, ip netip.Addr) bool {
+	ip = ip.Unmap().WithZone("")
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func inRanges(ranges []portRange, port int) bool {
+	for _, r := range ranges {
+		if port >= r.from && port <= r.to {
+			return true
+		}
+	}
+	return false
+}
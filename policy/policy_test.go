@@ -0,0 +1,136 @@
+package policy
+
+import (
+	"testing"
+)
+
+const testPolicy = `
+default: deny
+rules:
+- name: metadata
+  action: deny
+  cidrs: [169.254.0.0/16]
+- name: kube-apiserver
+  action: allow
+  protos: [tcp]
+  hosts: ["*.svc.cluster.local", "Kubernetes.Default"]
+  ports: ["443", "6443"]
+- name: pods
+  action: allow
+  protos: [tcp]
+  cidrs: [10.42.0.0/16]
+  ports: ["8000-8999"]
+- name: docker
+  action: allow
+  protos: [unix]
+  paths: [/var/run/*.sock]
+denyResolvedCIDRs: [127.0.0.0/8]
+`
+
+func TestParse(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Rules) != 4 || p.Default != Deny {
+		t.Errorf("unexpected policy: %+v", p)
+	}
+
+	json := `{"default": "allow", "rules": [{"action": "deny", "ports": ["22"]}]}`
+	if p, err = Parse([]byte(json)); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Rules) != 1 || p.Default != Allow {
+		t.Errorf("unexpected policy: %+v", p)
+	}
+
+	if p, err = Parse(nil); err != nil || len(p.Rules) != 0 {
+		t.Errorf("expected an empty policy, got: %+v, %v", p, err)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"action":       `rules: [{action: maybe}]`,
+		"default":      `default: maybe`,
+		"cidr":         `rules: [{action: allow, cidrs: [10.0.0.0/33]}]`,
+		"port":         `rules: [{action: allow, ports: ["70000"]}]`,
+		"port range":   `rules: [{action: allow, ports: ["9000-8000"]}]`,
+		"host":         `rules: [{action: allow, hosts: ["[a-"]}]`,
+		"paths":        `rules: [{action: allow, paths: [/tmp/x.sock], ports: ["80"]}]`,
+		"resolved":     `denyResolvedCIDRs: [localhost]`,
+		"unknown":      `denyResolvedCidrs: [169.254.0.0/16]`,
+		"unknown json": `{"rules": [{"action": "deny", "host": ["metadata.google.internal"]}]}`,
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := compile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		proto, address string
+		want           Decision
+	}{
+		{"tcp", "api.default.svc.cluster.local:443", Decision{Allowed: true, Rule: "kube-apiserver"}},
+		{"tcp", "kubernetes.default:6443", Decision{Allowed: true, Rule: "kube-apiserver"}},
+		{"tcp", "api.default.svc.cluster.local:80", Decision{}},
+		{"udp", "api.default.svc.cluster.local:443", Decision{}},
+		{"tcp", "169.254.169.254:80", Decision{Rule: "metadata"}},
+		{"tcp", "10.42.1.5:8080", Decision{Allowed: true, Rule: "pods"}},
+		{"tcp", "10.43.1.5:8080", Decision{}},
+		{"tcp", "pod.example.com:8080", Decision{}},
+		{"unix", "/var/run/docker.sock", Decision{Allowed: true, Rule: "docker"}},
+		{"unix", "/var/run/../../etc/shadow.sock", Decision{}},
+		{"unix", "/var/run/sub/docker.sock", Decision{}},
+		{"tcp", "not an address", Decision{}},
+	}
+	for _, tt := range tests {
+		if got := compiled.evaluate(tt.proto, tt.address); got != tt.want {
+			t.Errorf("%s/%s: got %+v, want %+v", tt.proto, tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateNormalizesHosts(t *testing.T) {
+	p, err := Parse([]byte(`
+default: allow
+rules:
+- name: metadata
+  action: deny
+  hosts: [metadata.google.internal]
+- name: kube-apiserver
+  action: deny
+  hosts: ["Kubernetes.Default."]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := compile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for address, rule := range map[string]string{
+		"metadata.google.internal:80":  "metadata",
+		"metadata.google.internal.:80": "metadata",
+		"Metadata.Google.Internal.:80": "metadata",
+		"kubernetes.default:443":       "kube-apiserver",
+		"kubernetes.default.:443":      "kube-apiserver",
+		"KUBERNETES.default:443":       "kube-apiserver",
+	} {
+		if got := compiled.evaluate("tcp", address); got.Allowed || got.Rule != rule {
+			t.Errorf("%s: expected to be denied by %s, got %+v", address, rule, got)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package remotedialer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+)
+
+// DialMetadataSourceAddress is the DialMetadata key holding the address of the original caller of a dial, as ip:port,
+// sent to the backends configured with ClientOptions.ProxyProtocol
+const DialMetadataSourceAddress = "source-address"
+
+// WithSourceAddress returns a copy of ctx carrying the address of the original caller of the dials performed with it,
+// such as the RemoteAddr of the HTTP request triggering them
+func WithSourceAddress(ctx context.Context, address string) context.Context {
+	return WithDialMetadata(ctx, DialMetadata{DialMetadataSourceAddress: address})
+}
+
+// ProxyProtocolVersion is the version of the PROXY protocol header sent to a backend
+type ProxyProtocolVersion int
+
+const (
+	// ProxyProtocolV1 sends the human-readable header
+	ProxyProtocolV1 ProxyProtocolVersion = 1
+	// ProxyProtocolV2 sends the binary header
+	ProxyProtocolV2 ProxyProtocolVersion = 2
+)
+
+// proxyProtocolV2Signature starts every PROXY protocol v2 header
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// writeProxyProtocolHeader writes the PROXY protocol header describing a connection from source to destination.
+// The connection is described as unknown if either address is not a TCP address, so the backend uses its own view of it.
+func writeProxyProtocolHeader(w io.Writer, version ProxyProtocolVersion, source string, destination net.Addr) error {
+	src, _ := netip.ParseAddrPort(source)
+	var dst netip.AddrPort
+	if tcpAddr, ok := destination.(*net.TCPAddr); ok {
+		dst = tcpAddr.AddrPort()
+	}
+
+	var header []byte
+	switch version {
+	case ProxyProtocolV1:
+		header = proxyProtocolV1Header(src, dst)
+	case ProxyProtocolV2:
+		header = proxyProtocolV2Header(src, dst)
+	default:
+		return fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+	_, err := w.Write(header)
+	return err
+}
+
+// proxyProtocolAddrs returns the addresses in the same family, mapping IPv4 addresses to IPv6 if they differ
+func proxyProtocolAddrs(src, dst netip.AddrPort) (srcAddr, dstAddr netip.Addr, ipv4, ok bool) {
+	if !src.IsValid() || !dst.IsValid() {
+		return srcAddr, dstAddr, false, false
+	}
+	srcAddr, dstAddr = src.Addr().Unmap().WithZone(""), dst.Addr().Unmap().WithZone("")
+	if srcAddr.Is4() && dstAddr.Is4() {
+		return srcAddr, dstAddr, true, true
+	}
+	return netip.AddrFrom16(srcAddr.As16()), netip.AddrFrom16(dstAddr.As16()), false, true
+}
+
+func proxyProtocolV1Header(src, dst netip.AddrPort) []byte {
+	srcAddr, dstAddr, ipv4, ok := proxyProtocolAddrs(src, dst)
+	if !ok {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	family := "TCP6"
+	if ipv4 {
+		family = "TCP4"
+	}
+	return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, srcAddr, dstAddr, src.Port(), dst.Port())
+}
+
+func proxyProtocolV2Header(src, dst netip.AddrPort) []byte {
+	header := append([]byte{}, proxyProtocolV2Signature...)
+	srcAddr, dstAddr, ipv4, ok := proxyProtocolAddrs(src, dst)
+	if !ok {
+		// version 2, LOCAL command, unspecified family and no addresses
+		return append(header, 0x20, 0x00, 0x00, 0x00)
+	}
+
+	// version 2, PROXY command, then TCP over IPv4 or IPv6
+	header = append(header, 0x21, 0x21)
+	if ipv4 {
+		header[len(header)-1] = 0x11
+	}
+	addrs := append(srcAddr.AsSlice(), dstAddr.AsSlice()...)
+	addrs = binary.BigEndian.AppendUint16(addrs, src.Port())
+	addrs = binary.BigEndian.AppendUint16(addrs, dst.Port())
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+	return append(header, addrs...)
+}
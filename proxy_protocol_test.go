@@ -0,0 +1,131 @@
+package remotedialer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestProxyProtocolHeader(t *testing.T) {
+	ipv4 := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 443}
+	ipv6 := &net.TCPAddr{IP: net.ParseIP("fd00::2"), Port: 443}
+	tests := []struct {
+		name        string
+		version     ProxyProtocolVersion
+		source      string
+		destination net.Addr
+		want        []byte
+	}{
+		{name: "v1 ipv4", version: ProxyProtocolV1, source: "192.0.2.1:51234", destination: ipv4, want: []byte("PROXY TCP4 192.0.2.1 10.0.0.2 51234 443\r\n")},
+		{name: "v1 mixed", version: ProxyProtocolV1, source: "192.0.2.1:51234", destination: ipv6, want: []byte("PROXY TCP6 ::ffff:192.0.2.1 fd00::2 51234 443\r\n")},
+		{name: "v1 unknown", version: ProxyProtocolV1, destination: ipv4, want: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v2 ipv4", version: ProxyProtocolV2, source: "192.0.2.1:51234", destination: ipv4, want: append(append([]byte{}, proxyProtocolV2Signature...),
+			0x21, 0x11, 0x00, 0x0c, 192, 0, 2, 1, 10, 0, 0, 2, 0xc8, 0x22, 0x01, 0xbb)},
+		{name: "v2 unknown", version: ProxyProtocolV2, source: "192.0.2.1:51234", destination: &net.UnixAddr{Name: "/run/app.sock"}, want: append(append([]byte{}, proxyProtocolV2Signature...),
+			0x20, 0x00, 0x00, 0x00)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeProxyProtocolHeader(&buf, tt.version, tt.source, tt.destination); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), tt.want) {
+				t.Errorf("unexpected header, got: %q, want: %q", buf.Bytes(), tt.want)
+			}
+		})
+	}
+
+	// IPv6 addresses take 36 bytes
+	header := proxyProtocolV2Header(netip.MustParseAddrPort("[2001:db8::1]:51234"), ipv6.AddrPort())
+	if got := len(header) - len(proxyProtocolV2Signature) - 4; got != 36 {
+		t.Errorf("expected 36 bytes of IPv6 addresses, got: %d", got)
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	headers := make(chan string, 1)
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		headers <- line
+	}()
+
+	opts := ClientOptions{
+		ProxyProtocol: map[string]ProxyProtocolVersion{backend.Addr().String(): ProxyProtocolV1},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, nil)
+
+	conn, err := server.Dialer("client")(WithSourceAddress(ctx, "192.0.2.1:51234"), "tcp", backend.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case header := <-headers:
+		if want := fmt.Sprintf("PROXY TCP4 192.0.2.1 127.0.0.1 51234 %d\r\n", backend.Addr().(*net.TCPAddr).Port); header != want {
+			t.Errorf("unexpected header, got: %q, want: %q", header, want)
+		}
+	case <-ctx.Done():
+		t.Fatal("the backend didn't receive a header")
+	}
+}
+
+func TestProxyProtocolInMemory(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	endpoints := NewEndpoints()
+	if err := endpoints.Handle("steve", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "hello from steve")
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	// the header is not sent to the in-memory server, which would fail to parse the request
+	opts := ClientOptions{
+		Endpoints:     endpoints,
+		ProxyProtocol: map[string]ProxyProtocolVersion{"local://steve": ProxyProtocolV1},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, nil)
+
+	dialer := server.Dialer("client")
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer(WithSourceAddress(ctx, "192.0.2.1:51234"), "tcp", "local://steve")
+			},
+		},
+	}
+	resp, err := client.Get("http://steve/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusOK || string(body) != "hello from steve" {
+		t.Errorf("unexpected response, got: %d %q", resp.StatusCode, body)
+	}
+}
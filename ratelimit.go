@@ -0,0 +1,217 @@
+package remotedialer
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Rate limit scopes, as reported in metrics
+const (
+	rateLimitScopeClient     = "client"
+	rateLimitScopeSession    = "session"
+	rateLimitScopeConnection = "connection"
+)
+
+type direction string
+
+const (
+	directionIn  direction = "in"
+	directionOut direction = "out"
+)
+
+// rateLimitQuantum is the maximum number of bytes transferred at once by a connection under a limit.
+// Keeping it small interleaves the connections sharing a limit, so they get a fair share of the bandwidth.
+const rateLimitQuantum = MaxRead
+
+// RateLimit is a token bucket limit on bandwidth, applied independently to the data sent and received.
+// The zero value means no limit.
+type RateLimit struct {
+	// BytesPerSecond is the sustained rate allowed
+	BytesPerSecond float64
+	// Burst is the maximum number of bytes transferred at once after a period of inactivity.
+	// BytesPerSecond is used if zero, with a minimum of MaxRead bytes.
+	Burst int
+}
+
+func (l RateLimit) limited() bool {
+	return l.BytesPerSecond > 0
+}
+
+func (l RateLimit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return max(int(l.BytesPerSecond), MaxRead)
+}
+
+// bandwidthLimiter holds a token bucket for each direction, whose limits can be changed at any time
+type bandwidthLimiter struct {
+	in, out *rate.Limiter
+}
+
+func newBandwidthLimiter(limit RateLimit) *bandwidthLimiter {
+	l := &bandwidthLimiter{
+		in:  rate.NewLimiter(rate.Inf, 0),
+		out: rate.NewLimiter(rate.Inf, 0),
+	}
+	l.set(limit)
+	return l
+}
+
+func (l *bandwidthLimiter) set(limit RateLimit) {
+	for _, lim := range []*rate.Limiter{l.in, l.out} {
+		if !limit.limited() {
+			lim.SetLimit(rate.Inf)
+			continue
+		}
+		lim.SetBurst(limit.burst())
+		lim.SetLimit(rate.Limit(limit.BytesPerSecond))
+	}
+}
+
+func (l *bandwidthLimiter) get(dir direction) *rate.Limiter {
+	if l == nil {
+		return nil
+	}
+	if dir == directionIn {
+		return l.in
+	}
+	return l.out
+}
+
+// SetClientRateLimit changes at runtime the bandwidth limit shared by all the sessions of a client key,
+// overriding ClientRateLimit. A zero RateLimit removes the limit.
+func (s *Server) SetClientRateLimit(clientKey string, limit RateLimit) {
+	s.rateLimitLock.Lock()
+	defer s.rateLimitLock.Unlock()
+
+	s.clientRateLimits[clientKey] = limit
+	if limiter, ok := s.clientLimiters[clientKey]; ok {
+		limiter.set(limit)
+	}
+	metricsOrDefault(s.Metrics).RateLimitSet(clientKey, rateLimitScopeClient, limit.BytesPerSecond)
+}
+
+// clientLimiter returns the limiter shared by the sessions of a client key, which must be released with
+// releaseClientLimiter. It's created even without a limit, so SetClientRateLimit applies to the connected sessions.
+func (s *Server) clientLimiter(clientKey string) *bandwidthLimiter {
+	s.rateLimitLock.Lock()
+	defer s.rateLimitLock.Unlock()
+
+	if limiter, ok := s.clientLimiters[clientKey]; ok {
+		s.clientLimiterRefs[clientKey]++
+		return limiter
+	}
+	limit, ok := s.clientRateLimits[clientKey]
+	if !ok {
+		limit = s.ClientRateLimit
+	}
+	limiter := newBandwidthLimiter(limit)
+	s.clientLimiters[clientKey] = limiter
+	s.clientLimiterRefs[clientKey] = 1
+	if limit.limited() {
+		metricsOrDefault(s.Metrics).RateLimitSet(clientKey, rateLimitScopeClient, limit.BytesPerSecond)
+	}
+	return limiter
+}
+
+// releaseClientLimiter forgets the limiter of a client key once the last session using it is released, so a session
+// reconnecting before the previous one is gone keeps sharing the same limiter
+func (s *Server) releaseClientLimiter(clientKey string) {
+	s.rateLimitLock.Lock()
+	defer s.rateLimitLock.Unlock()
+
+	if s.clientLimiterRefs[clientKey]--; s.clientLimiterRefs[clientKey] <= 0 {
+		delete(s.clientLimiterRefs, clientKey)
+		delete(s.clientLimiters, clientKey)
+	}
+}
+
+// SetRateLimit changes at runtime the bandwidth limit of this session. A zero RateLimit removes the limit.
+func (s *Session) SetRateLimit(limit RateLimit) {
+	s.limiter.set(limit)
+	s.metrics.RateLimitSet(s.clientKey, rateLimitScopeSession, limit.BytesPerSecond)
+}
+
+// SetConnectionRateLimit changes at runtime the bandwidth limit of every connection of this session, including future ones.
+// A zero RateLimit removes the limit.
+func (s *Session) SetConnectionRateLimit(limit RateLimit) {
+	s.Lock()
+	s.connRateLimit = limit
+	conns := make([]*connection, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.Unlock()
+
+	for _, c := range conns {
+		c.limiter.set(limit)
+	}
+	s.metrics.RateLimitSet(s.clientKey, rateLimitScopeConnection, limit.BytesPerSecond)
+}
+
+func (s *Session) connectionRateLimit() RateLimit {
+	s.RLock()
+	defer s.RUnlock()
+	return s.connRateLimit
+}
+
+// limiters returns the token buckets applying to a direction of this connection, from the narrowest to the widest scope
+func (c *connection) limiters(dir direction) []*rate.Limiter {
+	var res []*rate.Limiter
+	for _, l := range []*bandwidthLimiter{c.limiter, c.session.limiter, c.session.clientLimiter} {
+		if lim := l.get(dir); lim != nil && lim.Limit() != rate.Inf {
+			res = append(res, lim)
+		}
+	}
+	return res
+}
+
+// rateLimitChunk returns the number of bytes that can be transferred at once, or 0 if the direction is not limited
+func (c *connection) rateLimitChunk(dir direction) int {
+	limiters := c.limiters(dir)
+	if len(limiters) == 0 {
+		return 0
+	}
+	chunk := rateLimitQuantum
+	for _, lim := range limiters {
+		chunk = min(chunk, lim.Burst())
+	}
+	return max(chunk, 1)
+}
+
+// waitBandwidth blocks until n bytes can be transferred in the given direction under every applicable limit
+func (c *connection) waitBandwidth(ctx context.Context, dir direction, n int) error {
+	now := time.Now()
+	var (
+		delay        time.Duration
+		reservations []*rate.Reservation
+	)
+	for _, lim := range c.limiters(dir) {
+		// the burst may have been lowered since the chunk size was computed
+		r := lim.ReserveN(now, min(n, lim.Burst()))
+		if !r.OK() {
+			continue
+		}
+		reservations = append(reservations, r)
+		delay = max(delay, r.DelayFrom(now))
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	c.session.metrics.RateLimited(c.session.clientKey, string(dir), delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		for _, r := range reservations {
+			r.Cancel()
+		}
+		return ctx.Err()
+	}
+}
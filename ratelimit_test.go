@@ -0,0 +1,177 @@
+package remotedialer
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestConnectionWriteRateLimit(t *testing.T) {
+	t.Parallel()
+
+	s := setupDummySession(t, 0)
+	var messages atomic.Int32
+	s.conn = &fakeWSConn{
+		writeMessageCallback: func(int, time.Time, []byte) error {
+			messages.Add(1)
+			return nil
+		},
+	}
+	s.SetConnectionRateLimit(RateLimit{BytesPerSecond: 32 * 1024, Burst: 8 * 1024})
+	connID := getDummyConnectionID()
+	conn := newConnection(context.Background(), connID, s, "tcp", "test", true)
+	s.addConnection(connID, conn)
+
+	start := time.Now()
+	n, err := conn.Write(make([]byte, 24*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 24*1024 {
+		t.Errorf("incorrect number of bytes written, got: %d, want: %d", n, 24*1024)
+	}
+	if got, want := messages.Load(), int32(3); got != want {
+		t.Errorf("incorrect number of messages, got: %d, want: %d", got, want)
+	}
+	// at most the burst is sent right away, the rest at 32KiB/s
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("write was not rate limited, took %s", elapsed)
+	}
+
+	s.SetConnectionRateLimit(RateLimit{})
+	messages.Store(0)
+	start = time.Now()
+	if _, err := conn.Write(make([]byte, 24*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := messages.Load(), int32(1); got != want {
+		t.Errorf("unlimited writes should not be split, got %d messages", got)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("unlimited write was delayed for %s", elapsed)
+	}
+}
+
+func TestConnectionWriteRateLimitDeadline(t *testing.T) {
+	t.Parallel()
+
+	s := setupDummySession(t, 0)
+	s.conn = &fakeWSConn{
+		writeMessageCallback: func(int, time.Time, []byte) error {
+			return nil
+		},
+	}
+	s.SetRateLimit(RateLimit{BytesPerSecond: 1024, Burst: 1024})
+	conn := newConnection(context.Background(), getDummyConnectionID(), s, "tcp", "test", true)
+	if err := conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, 8*1024)); err == nil {
+		t.Error("expected the write deadline to interrupt the wait for bandwidth")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("write deadline was not honored, took %s", elapsed)
+	}
+}
+
+func TestConnectionReadRateLimitDeadline(t *testing.T) {
+	t.Parallel()
+
+	s := setupDummySession(t, 0)
+	s.conn = &fakeWSConn{
+		writeMessageCallback: func(int, time.Time, []byte) error {
+			return nil
+		},
+	}
+	s.SetRateLimit(RateLimit{BytesPerSecond: 256, Burst: 1024})
+	connID := getDummyConnectionID()
+	conn := newConnection(context.Background(), connID, s, "tcp", "test", true)
+	s.addConnection(connID, conn)
+	if err := conn.OnData(bytes.NewReader(make([]byte, 8*1024))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the burst is read right away, waiting for the bandwidth used by the next read is interrupted by the deadline
+	buf := make([]byte, 1024)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("read deadline was not honored, took %s", elapsed)
+	}
+
+	// and by closing the connection
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(100*time.Millisecond, func() { _ = conn.Close() })
+	start = time.Now()
+	_, _ = conn.Read(buf)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("closing the connection didn't interrupt the read, took %s", elapsed)
+	}
+}
+
+func TestServer_ClientRateLimit(t *testing.T) {
+	t.Parallel()
+
+	server := New(nil, DefaultErrorWriter)
+	if limiter := server.clientLimiter("unlimited"); limiter == nil || limiter.out.Limit() != rate.Inf {
+		t.Error("expected an unlimited limiter without a limit")
+	}
+
+	server.ClientRateLimit = RateLimit{BytesPerSecond: 1024}
+	limiter := server.clientLimiter("client")
+	if limiter == nil || limiter != server.clientLimiter("client") {
+		t.Fatal("expected the sessions of a client to share a limiter")
+	}
+
+	server.SetClientRateLimit("client", RateLimit{BytesPerSecond: 2048})
+	if got, want := float64(limiter.out.Limit()), 2048.0; got != want {
+		t.Errorf("limit was not updated at runtime, got: %v, want: %v", got, want)
+	}
+
+	// a reconnecting session acquires the limiter before the previous one releases it
+	reconnected := server.clientLimiter("client")
+	server.releaseClientLimiter("client")
+	server.releaseClientLimiter("client")
+	if reconnected != limiter || server.clientLimiter("client") != limiter {
+		t.Error("expected the limiter to be shared until its last session is released")
+	}
+
+	server.releaseClientLimiter("client")
+	server.releaseClientLimiter("client")
+	if server.clientLimiter("client") == limiter {
+		t.Error("expected the limiter to be released without sessions")
+	}
+}
+
+func TestServer_SetClientRateLimitConnected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the client connects without a limit, which is set while it's connected
+	server := newTestClientWithOptions(ctx, t, ClientOptions{}, nil)
+	server.SetClientRateLimit("client", RateLimit{BytesPerSecond: 1024})
+
+	sessions := server.sessions.getSessions("client")
+	if len(sessions) != 1 {
+		t.Fatalf("expected one session, got: %d", len(sessions))
+	}
+	if got, want := float64(sessions[0].clientLimiter.out.Limit()), 1024.0; got != want {
+		t.Errorf("limit was not applied to the connected session, got: %v, want: %v", got, want)
+	}
+}
@@ -5,10 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"sync"
 	"time"
-
-	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -22,12 +21,14 @@ type readBuffer struct {
 	buf                       bytes.Buffer
 	err                       error
 	backPressure              *backPressure
+	logger                    *slog.Logger
 }
 
-func newReadBuffer(id int64, backPressure *backPressure) *readBuffer {
+func newReadBuffer(id int64, backPressure *backPressure, logger *slog.Logger) *readBuffer {
 	return &readBuffer{
 		id:           id,
 		backPressure: backPressure,
+		logger:       logger,
 		cond: sync.Cond{
 			L: &sync.Mutex{},
 		},
@@ -40,17 +41,26 @@ func (r *readBuffer) Status() string {
 	return fmt.Sprintf("%d/%d", r.readCount, r.offerCount)
 }
 
-func (r *readBuffer) Offer(reader io.Reader) error {
+// stats returns the total number of bytes offered to this buffer, and how many of those are still waiting to be read
+func (r *readBuffer) stats() (offered int64, buffered int) {
+	r.cond.L.Lock()
+	defer r.cond.L.Unlock()
+	return r.offerCount, r.buf.Len()
+}
+
+// Offer copies all the data from reader into the buffer, returning the number of bytes added
+func (r *readBuffer) Offer(reader io.Reader) (int64, error) {
 	r.cond.L.Lock()
 	defer r.cond.L.Unlock()
 
 	if r.err != nil {
-		return r.err
+		return 0, r.err
 	}
 
-	if n, err := io.Copy(&r.buf, reader); err != nil {
+	n, err := io.Copy(&r.buf, reader)
+	if err != nil {
 		r.offerCount += n
-		return err
+		return n, err
 	} else if n > 0 {
 		r.offerCount += n
 		r.cond.Broadcast()
@@ -61,10 +71,10 @@ func (r *readBuffer) Offer(reader io.Reader) error {
 	}
 
 	if r.buf.Len() > MaxBuffer*2 {
-		logrus.Debugf("remotedialer buffer exceeded id=%d, length: %d", r.id, r.buf.Len())
+		r.logger.Debug("remotedialer buffer exceeded", "length", r.buf.Len())
 	}
 
-	return nil
+	return n, nil
 }
 
 func (r *readBuffer) Read(b []byte) (int, error) {
@@ -89,7 +99,7 @@ func (r *readBuffer) Read(b []byte) (int, error) {
 		}
 
 		if r.buf.Cap() > MaxBuffer/8 {
-			logrus.Debugf("resetting remotedialer buffer id=%d to zero, old cap %d", r.id, r.buf.Cap())
+			r.logger.Debug("resetting remotedialer buffer to zero", "oldCap", r.buf.Cap())
 			r.buf = bytes.Buffer{}
 		}
 
@@ -115,6 +125,30 @@ func (r *readBuffer) Read(b []byte) (int, error) {
 	}
 }
 
+// discard drops any data not read yet, returning the number of bytes dropped
+func (r *readBuffer) discard() int {
+	r.cond.L.Lock()
+	defer r.cond.L.Unlock()
+
+	n := r.buf.Len()
+	r.buf = bytes.Buffer{}
+	return n
+}
+
+func (r *readBuffer) getDeadline() time.Time {
+	r.cond.L.Lock()
+	defer r.cond.L.Unlock()
+	return r.deadline
+}
+
+func (r *readBuffer) setDeadline(t time.Time) {
+	r.cond.L.Lock()
+	defer r.cond.L.Unlock()
+	r.deadline = t
+	// readers waiting for data re-evaluate the new deadline
+	r.cond.Broadcast()
+}
+
 func (r *readBuffer) Close(err error) error {
 	r.cond.L.Lock()
 	defer r.cond.L.Unlock()
@@ -1,18 +1,23 @@
 package remotedialer
 
 import (
+	"log/slog"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/rancher/remotedialer/metrics"
 )
 
 var (
 	errFailedAuth       = errors.New("failed authentication")
 	errWrongMessageType = errors.New("wrong websocket message type")
+	errRevoked          = errors.New("client access revoked")
 )
 
 type Authorizer func(req *http.Request) (clientKey string, authed bool, err error)
@@ -32,14 +37,81 @@ type Server struct {
 	sessions                *sessionManager
 	peers                   map[string]peer
 	peerLock                sync.Mutex
+	revoked                 map[string]time.Time
+	revokedLock             sync.Mutex
+	captures                map[string]*capture
+	captureLock             sync.Mutex
+	rateLimitLock           sync.Mutex
+	clientRateLimits        map[string]RateLimit
+	clientLimiters          map[string]*bandwidthLimiter
+	clientLimiterRefs       map[string]int
+	admissionLock           sync.Mutex
+	clientAdmissions        map[string]*connAdmission
+	clientAdmissionRefs     map[string]int
+
+	// Metrics records the metrics of all the sessions handled by this Server. metrics.Default is used if nil.
+	Metrics metrics.Recorder
+	// Logger receives the log records of this Server and its sessions. The global logrus logger is used if nil.
+	Logger *slog.Logger
+	// Tracer receives the events of all the sessions handled by this Server. No events are traced if nil.
+	Tracer Tracer
+	// TracerProvider creates the OpenTelemetry spans of all the sessions handled by this Server. The global TracerProvider is used if nil.
+	TracerProvider trace.TracerProvider
+	// Propagator injects the trace context of the dial context into Connect messages, so clients can continue the trace.
+	// Trace context is not sent if nil, nor to clients that don't announce the Connect message extension in their handshake.
+	Propagator propagation.TextMapPropagator
+	// AuditSink receives the audit events of every connection of the sessions handled by this Server. No events are emitted if nil.
+	AuditSink AuditSink
+	// ClientRateLimit limits the bandwidth shared by all the sessions of each client key.
+	// It can be overridden for a given client key with SetClientRateLimit.
+	ClientRateLimit RateLimit
+	// SessionRateLimit limits the bandwidth of each client session
+	SessionRateLimit RateLimit
+	// ConnectionRateLimit limits the bandwidth of each connection of the client sessions
+	ConnectionRateLimit RateLimit
+	// ClientConnectionLimits bounds the connections shared by all the sessions of each client key,
+	// both those dialed through this Server and those requested by the clients.
+	// Connections over the limits are rejected with a ConnectionLimitError.
+	ClientConnectionLimits ConnectionLimits
+	// SessionConnectionLimits bounds the connections of each client session, peer sessions are not limited
+	SessionConnectionLimits ConnectionLimits
+	// MemoryBudget bounds the data buffered by the connections of all the sessions handled by this Server, including peers.
+	// It can be shared with other Servers and client sessions. The buffered data is not bounded if nil.
+	MemoryBudget *MemoryBudget
+	// IdleTimeout closes the connections without data sent or received for this long with ErrIdleTimeout. Connections never expire if zero.
+	IdleTimeout time.Duration
+	// MaxPausedDuration closes the connections paused by back pressure for this long with ErrPausedTooLong,
+	// so a reader that stopped reading doesn't keep the sender blocked forever. Connections can stay paused indefinitely if zero.
+	MaxPausedDuration time.Duration
+	// DialInterceptors wrap every dial performed through Dialer, including those requested by peers, the first one being the outermost
+	DialInterceptors []DialInterceptor
+	// ConnectAuthorizerForClient, if set, returns the ConnectAuthorizer allowing the connections requested by a client
+	// to services behind this Server, instead of ClientConnectAuthorizer. Peer sessions are not affected.
+	ConnectAuthorizerForClient func(clientKey string) ConnectAuthorizer
+	// DialerForClient, if set, returns the Dialer used to dial the connections requested by a client.
+	// A default net.Dialer is used if nil, or if it returns nil.
+	DialerForClient func(clientKey string) Dialer
+	// WaitForSessionOnDial makes dialers returned by Dialer wait for a session for the client to be available, instead of failing immediately.
+	// The wait is bounded by the dial context, so it should carry a deadline.
+	WaitForSessionOnDial bool
+	// PingClients makes this Server send pings to its client sessions, measuring their round trip time for the metrics.
+	// Only clients send pings if false.
+	PingClients bool
 }
 
 func New(auth Authorizer, errorWriter ErrorWriter) *Server {
 	return &Server{
-		peers:       map[string]peer{},
-		authorizer:  auth,
-		errorWriter: errorWriter,
-		sessions:    newSessionManager(),
+		peers:               map[string]peer{},
+		revoked:             map[string]time.Time{},
+		captures:            map[string]*capture{},
+		clientRateLimits:    map[string]RateLimit{},
+		clientLimiters:      map[string]*bandwidthLimiter{},
+		clientLimiterRefs:   map[string]int{},
+		clientAdmissions:    map[string]*connAdmission{},
+		clientAdmissionRefs: map[string]int{},
+		authorizer:          auth,
+		errorWriter:         errorWriter,
+		sessions:            newSessionManager(),
 	}
 }
 
@@ -53,8 +125,12 @@ func (s *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		s.errorWriter(rw, req, 401, errFailedAuth)
 		return
 	}
+	if s.isRevoked(clientKey) {
+		s.errorWriter(rw, req, 403, errRevoked)
+		return
+	}
 
-	logrus.Infof("Handling backend connection request [%s]", clientKey)
+	s.logger().Info("Handling backend connection request", LogKeyClientKey, clientKey)
 
 	upgrader := websocket.Upgrader{
 		HandshakeTimeout: 5 * time.Second,
@@ -62,20 +138,66 @@ func (s *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		Error:            s.errorWriter,
 	}
 
-	wsConn, err := upgrader.Upgrade(rw, req, nil)
+	wsConn, err := upgrader.Upgrade(rw, req, withFeatures(nil))
 	if err != nil {
 		s.errorWriter(rw, req, 400, errors.Wrapf(err, "Error during upgrade for host [%v]", clientKey))
 		return
 	}
 
-	session := s.sessions.add(clientKey, wsConn, peer)
+	cfg := s.sessionConfig()
+	if !peer {
+		cfg.clientLimiter = s.clientLimiter(clientKey)
+		defer s.releaseClientLimiter(clientKey)
+		cfg.connLimits = s.SessionConnectionLimits
+		if cfg.clientAdmission = s.clientAdmission(clientKey); cfg.clientAdmission != nil {
+			defer s.releaseClientAdmission(clientKey)
+		}
+	}
+	cfg.connectExtension = hasFeature(req.Header, featureConnectExtension)
+	session := s.sessions.add(clientKey, wsConn, peer, cfg)
 	session.auth = s.ClientConnectAuthorizer
+	if !peer {
+		if s.ConnectAuthorizerForClient != nil {
+			session.auth = s.ConnectAuthorizerForClient(clientKey)
+		}
+		if s.DialerForClient != nil {
+			session.dialer = s.DialerForClient(clientKey)
+		}
+	}
 	defer s.sessions.remove(session)
+	s.startSessionCapture(session)
+
+	// the client could have been revoked during the upgrade
+	if s.isRevoked(clientKey) {
+		session.disconnect(websocket.ClosePolicyViolation, errRevoked.Error())
+		return
+	}
 
 	code, err := session.Serve(req.Context())
 	if err != nil {
 		// Hijacked so we can't write to the client
-		logrus.Infof("error in remotedialer server [%d]: %v", code, err)
+		session.logger.Info("error in remotedialer server", "code", code, "error", err)
+	}
+}
+
+func (s *Server) logger() *slog.Logger {
+	return loggerOrDefault(s.Logger)
+}
+
+func (s *Server) sessionConfig() sessionConfig {
+	return sessionConfig{
+		metrics:        s.Metrics,
+		logger:         s.Logger,
+		tracer:         s.Tracer,
+		tracerProvider: s.TracerProvider,
+		propagator:     s.Propagator,
+		audit:          s.AuditSink,
+		sessionLimit:   s.SessionRateLimit,
+		connLimit:      s.ConnectionRateLimit,
+		budget:         s.MemoryBudget,
+		idleTimeout:    s.IdleTimeout,
+		maxPaused:      s.MaxPausedDuration,
+		pings:          s.PingClients,
 	}
 }
 
@@ -106,10 +106,12 @@ func main() {
 
 	if debug {
 		logrus.SetLevel(logrus.DebugLevel)
-		remotedialer.PrintTunnelData = true
 	}
 
 	handler := remotedialer.New(authorizer, remotedialer.DefaultErrorWriter)
+	if debug {
+		handler.Tracer = remotedialer.NewLogTracer(nil)
+	}
 	handler.PeerToken = peerToken
 	handler.PeerID = peerID
 
@@ -126,6 +128,9 @@ func main() {
 	clientHandler := func(rw http.ResponseWriter, req *http.Request) { Client(handler, rw, req) }
 	router.HandleFunc("/client/{id}/{scheme}/{host}", clientHandler)
 	router.HandleFunc("/client/{id}/{scheme}/{host}/{path...}", clientHandler)
+	if debug {
+		router.Handle("/debug/", http.StripPrefix("/debug", handler.DebugHandler()))
+	}
 
 	fmt.Println("Listening on ", addr)
 	http.ListenAndServe(addr, router)
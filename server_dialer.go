@@ -0,0 +1,90 @@
+package remotedialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// featureServerDial is the support of the connections dialed by clients, numbered apart from those dialed by the server
+const featureServerDial = "server-dial"
+
+var (
+	// ErrNoServerSession is returned by ServerDialer when the client is not connected and the dial context has no deadline
+	ErrNoServerSession = errors.New("not connected to the server")
+	// ErrServerDialUnsupported is returned by ServerDialer when the server is too old to accept connections dialed by clients
+	ErrServerDialUnsupported = errors.New("the server doesn't support connections dialed by clients")
+)
+
+// ServerDialer dials services behind the server through the tunnel of a client, following its reconnections.
+// It must be set in the ClientOptions passed to ConnectToProxyWithOptions, the server allowing the connections
+// with its ClientConnectAuthorizer or ConnectAuthorizerForClient.
+type ServerDialer struct {
+	lock    sync.Mutex
+	session *Session
+	// changed is closed and replaced whenever the session changes, waking up the dials waiting for one
+	changed chan struct{}
+}
+
+// NewServerDialer creates a ServerDialer, which can dial once the client is connected
+func NewServerDialer() *ServerDialer {
+	return &ServerDialer{
+		changed: make(chan struct{}),
+	}
+}
+
+// Dial dials the given address behind the server. If the client is not connected, it waits for it
+// until the context is done, or fails right away with ErrNoServerSession if the context has no deadline.
+// It fails with ErrServerDialUnsupported if the server didn't announce the support of connections dialed by clients
+// in the handshake, as dialing through older servers would tear down the tunnel.
+func (d *ServerDialer) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	session, err := d.waitForSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !session.serverDial {
+		return nil, ErrServerDialUnsupported
+	}
+	return session.Dial(ctx, network, address)
+}
+
+func (d *ServerDialer) waitForSession(ctx context.Context) (*Session, error) {
+	_, wait := ctx.Deadline()
+	for {
+		d.lock.Lock()
+		session, changed := d.session, d.changed
+		d.lock.Unlock()
+		if session != nil {
+			return session, nil
+		}
+		if !wait {
+			return nil, ErrNoServerSession
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, errors.Join(ErrNoServerSession, ctx.Err())
+		}
+	}
+}
+
+func (d *ServerDialer) setSession(session *Session) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.session = session
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// unsetSession forgets the session once disconnected, unless it was already replaced
+func (d *ServerDialer) unsetSession(session *Session) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.session == session {
+		d.session = nil
+	}
+}
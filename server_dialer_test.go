@@ -0,0 +1,142 @@
+package remotedialer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestServerDialer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := NewServerDialer()
+	if _, err := d.Dial(ctx, "tcp", "service:80"); !errors.Is(err, ErrNoServerSession) {
+		t.Fatalf("expected ErrNoServerSession before connecting, got: %v", err)
+	}
+
+	dialedKeys := make(chan string, 1)
+
+	opts := ClientOptions{
+		ServerDialer: d,
+		LocalDialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go func() {
+				<-ctx.Done()
+				server.Close()
+			}()
+			return client, nil
+		},
+	}
+	server := newTestClientWithOptions(ctx, t, opts, func(server *Server) {
+		server.ConnectAuthorizerForClient = func(clientKey string) ConnectAuthorizer {
+			return func(proto, address string) bool {
+				return address == "service:80"
+			}
+		}
+		server.DialerForClient = func(clientKey string) Dialer {
+			return func(ctx context.Context, network, address string) (net.Conn, error) {
+				dialedKeys <- clientKey
+				client, server := net.Pipe()
+				go func() {
+					defer server.Close()
+					_, _ = io.Copy(server, server)
+				}()
+				return client, nil
+			}
+		}
+	})
+
+	dialCtx, dialCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer dialCancel()
+	conn, err := d.Dial(dialCtx, "tcp", "service:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case clientKey := <-dialedKeys:
+		if clientKey != "client" {
+			t.Errorf("incorrect client key, got: %q", clientKey)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server dialer was not called")
+	}
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	} else if string(buf) != "hello" {
+		t.Errorf("unexpected echo, got: %q", buf)
+	}
+
+	// connections dialed by each end use disjoint IDs
+	serverConn, err := server.Dialer("client")(dialCtx, "tcp", "agent:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverConn.Close()
+	if id := conn.(Conn).ID(); id%2 != 1 {
+		t.Errorf("expected an odd ID for the connection dialed by the client, got: %d", id)
+	}
+	if id := serverConn.(Conn).ID(); id%2 != 0 {
+		t.Errorf("expected an even ID for the connection dialed by the server, got: %d", id)
+	}
+
+	rejected, err := d.Dial(dialCtx, "tcp", "other:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rejected.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := rejected.Read(buf); !errors.Is(err, ErrDialForbidden) {
+		t.Errorf("expected the connection to a service not allowed by the server to be forbidden, got: %v", err)
+	}
+	// the session is still usable
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerDialerLegacyServer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// servers older than ServerDialer announce no features in the handshake
+	upgrader := websocket.Upgrader{}
+	legacy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ws, err := upgrader.Upgrade(rw, req, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer legacy.Close()
+
+	d := NewServerDialer()
+	go ConnectToProxyWithOptions(ctx, "ws"+strings.TrimPrefix(legacy.URL, "http"), nil, func(string, string) bool { return true }, nil, ClientOptions{ServerDialer: d}, nil)
+
+	if _, err := d.Dial(ctx, "tcp", "service:80"); !errors.Is(err, ErrServerDialUnsupported) {
+		t.Errorf("expected dials through a legacy server to fail with ErrServerDialUnsupported, got: %v", err)
+	}
+}
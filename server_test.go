@@ -0,0 +1,54 @@
+package remotedialer
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/rancher/remotedialer/metrics"
+)
+
+func TestServer_Metrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newServerWithMetrics := func() (string, *Server, *prometheus.Registry) {
+		registry := prometheus.NewRegistry()
+		recorder, err := metrics.NewPrometheusRecorder(registry, metrics.LabelPolicy{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := New(func(*http.Request) (string, bool, error) { return "client", true, nil }, DefaultErrorWriter)
+		server.Metrics = recorder
+		address, err := newServer(ctx, server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return address, server, registry
+	}
+
+	address, server, registry := newServerWithMetrics()
+	_, _, otherRegistry := newServerWithMetrics()
+
+	if err := newTestClient(ctx, "ws://"+address); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.WaitForSession(ctx, "client"); err != nil {
+		t.Fatal(err)
+	}
+
+	const metric = "session_server_total_add_websocket_session"
+	if got, err := testutil.GatherAndCount(registry, metric); err != nil {
+		t.Fatal(err)
+	} else if got != 1 {
+		t.Errorf("incorrect number of series for %s, got: %d, want: 1", metric, got)
+	}
+	if got, err := testutil.GatherAndCount(otherRegistry, metric); err != nil {
+		t.Fatal(err)
+	} else if got != 0 {
+		t.Errorf("metrics recorded on an unrelated server, got: %d series, want: 0", got)
+	}
+}
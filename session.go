@@ -1,9 +1,12 @@
 package remotedialer
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"os"
 	"sort"
@@ -14,7 +17,11 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/rancher/remotedialer/metrics"
 )
 
 type Session struct {
@@ -27,10 +34,100 @@ type Session struct {
 	conns            map[int64]*connection
 	remoteClientKeys map[string]map[int]bool
 	auth             ConnectAuthorizer
+	dialAuth         DialAuthorizer
 	pingCancel       context.CancelFunc
 	pingWait         sync.WaitGroup
 	dialer           Dialer
 	client           bool
+	// remoteClientsChanged, if set, is called after a remote client key is added to this session
+	remoteClientsChanged func()
+	metrics              metrics.Recorder
+	logger               *slog.Logger
+	tracer               Tracer
+	otelTracer           trace.Tracer
+	propagator           propagation.TextMapPropagator
+	capture              atomic.Pointer[capture]
+	audit                AuditSink
+	// limiter applies to this session, clientLimiter is shared by all the sessions of the client key on a Server
+	limiter       *bandwidthLimiter
+	clientLimiter *bandwidthLimiter
+	connRateLimit RateLimit
+	// admission limits the connections of this session, clientAdmission those of all the sessions of the client key
+	admission       *connAdmission
+	clientAdmission *connAdmission
+	budget          *MemoryBudget
+	idleTimeout     time.Duration
+	maxPaused       time.Duration
+	// remoteSyncRanges is set once the remote end sent a SyncConnectionRanges message, so legacy ones are no longer needed
+	remoteSyncRanges atomic.Bool
+	// connectExtension is set if the remote end announced it can parse the Connect message extension during the handshake
+	connectExtension bool
+	// pings is set if this session sends pings, which only client sessions do by default
+	pings bool
+	// serverDial is set if the server announced it accepts the connections dialed by clients during the handshake
+	serverDial bool
+	// listeners are opened on the remote end by Listen, localListeners by this end on behalf of the remote end
+	listeners      map[int64]*remoteListener
+	localListeners map[int64]net.Listener
+	listenAuth     ConnectAuthorizer
+	endpoints      *Endpoints
+	httpPolicies   map[string]*HTTPPolicy
+	proxyProtocol  map[string]ProxyProtocolVersion
+}
+
+// connIDStep is the increment between the IDs of the connections dialed by a session.
+// Server sessions allocate even IDs and client sessions odd ones, so the connections dialed by each end never collide.
+// Servers older than this allocated every ID, so clients only dial through the servers announcing featureServerDial.
+const connIDStep = 2
+
+// sessionConfig holds the settings applied to every session handled by a Server
+type sessionConfig struct {
+	metrics         metrics.Recorder
+	logger          *slog.Logger
+	tracer          Tracer
+	tracerProvider  trace.TracerProvider
+	propagator      propagation.TextMapPropagator
+	audit           AuditSink
+	sessionLimit    RateLimit
+	connLimit       RateLimit
+	clientLimiter   *bandwidthLimiter
+	connLimits      ConnectionLimits
+	clientAdmission *connAdmission
+	budget          *MemoryBudget
+	idleTimeout     time.Duration
+	maxPaused       time.Duration
+	// connectExtension is set if the remote end announced it can parse the Connect message extension
+	connectExtension bool
+	// pings makes server sessions send pings, as client sessions always do
+	pings bool
+}
+
+func (c sessionConfig) apply(s *Session) {
+	s.metrics = metricsOrDefault(c.metrics)
+	s.logger = loggerOrDefault(c.logger).With(LogKeyClientKey, s.clientKey, LogKeySessionKey, s.sessionKey)
+	s.tracer = c.tracer
+	if s.tracer == nil {
+		s.tracer = defaultTracer(c.logger)
+	}
+	s.otelTracer = otelTracerOrDefault(c.tracerProvider)
+	s.propagator = c.propagator
+	s.audit = c.audit
+	s.limiter = newBandwidthLimiter(c.sessionLimit)
+	s.clientLimiter = c.clientLimiter
+	s.connRateLimit = c.connLimit
+	s.admission = newConnAdmission(admissionScopeSession, c.connLimits)
+	s.clientAdmission = c.clientAdmission
+	s.budget = c.budget
+	s.idleTimeout = c.idleTimeout
+	s.maxPaused = c.maxPaused
+	s.connectExtension = c.connectExtension
+	s.pings = c.pings
+	if c.sessionLimit.limited() {
+		s.metrics.RateLimitSet(s.clientKey, rateLimitScopeSession, c.sessionLimit.BytesPerSecond)
+	}
+	if c.connLimit.limited() {
+		s.metrics.RateLimitSet(s.clientKey, rateLimitScopeConnection, c.connLimit.BytesPerSecond)
+	}
 }
 
 // Use this defined type so we can share context between remotedialer and its clients
@@ -49,7 +146,9 @@ func ValueFromContext(ctx context.Context) string {
 	return ""
 }
 
-// PrintTunnelData No tunnel logging by default
+// PrintTunnelData enables logging the metadata of every message and connection event, for sessions without a Tracer.
+//
+// Deprecated: set a Tracer, such as the one returned by NewLogTracer, instead.
 var PrintTunnelData bool
 
 func init() {
@@ -58,30 +157,119 @@ func init() {
 	}
 }
 
+// ClientOptions holds the optional settings of a client Session
+type ClientOptions struct {
+	// LocalDialer is used to dial local connections on behalf of the remote host. A default net.Dialer is used if nil.
+	// The context it receives carries the DialMetadata sent by the remote host, see DialMetadataFromContext.
+	LocalDialer Dialer
+	// DialAuthorizer, if set, is used instead of the ConnectAuthorizer to allow connections requested by the remote host
+	DialAuthorizer DialAuthorizer
+	// Metrics records the metrics of the session. metrics.Default is used if nil.
+	Metrics metrics.Recorder
+	// Logger receives the log records of the session. The global logrus logger is used if nil.
+	Logger *slog.Logger
+	// Tracer receives the events of the session. No events are traced if nil.
+	Tracer Tracer
+	// TracerProvider creates the OpenTelemetry spans of the session. The global TracerProvider is used if nil.
+	TracerProvider trace.TracerProvider
+	// Propagator injects the trace context of the dial context into Connect messages sent by this session.
+	// Trace context is not sent if nil, but received trace context is always extracted, using W3C Trace Context if nil.
+	Propagator propagation.TextMapPropagator
+	// AuditSink receives the audit events of every connection of the session. No events are emitted if nil.
+	AuditSink AuditSink
+	// SessionRateLimit limits the bandwidth of the session, it can be changed later with Session.SetRateLimit
+	SessionRateLimit RateLimit
+	// ConnectionRateLimit limits the bandwidth of every connection, it can be changed later with Session.SetConnectionRateLimit
+	ConnectionRateLimit RateLimit
+	// ConnectionLimits bounds the connections of the session, both those dialed and those requested by the remote host.
+	// Connections over the limits are rejected with a ConnectionLimitError.
+	ConnectionLimits ConnectionLimits
+	// MemoryBudget bounds the data buffered by the connections of the session, it can be shared with other sessions and Servers
+	MemoryBudget *MemoryBudget
+	// IdleTimeout closes the connections without data sent or received for this long with ErrIdleTimeout. Connections never expire if zero.
+	IdleTimeout time.Duration
+	// MaxPausedDuration closes the connections paused by back pressure for this long with ErrPausedTooLong,
+	// so a reader that stopped reading doesn't keep the sender blocked forever. Connections can stay paused indefinitely if zero.
+	MaxPausedDuration time.Duration
+	// ServerDialer, if set, dials services behind the server through the session established by ConnectToProxyWithOptions
+	ServerDialer *ServerDialer
+	// ListenAuthorizer allows the listeners requested by the remote host with Session.Listen, which are refused if nil
+	ListenAuthorizer ConnectAuthorizer
+	// Endpoints serves the connections requested by the remote host to virtual addresses such as local://name in memory,
+	// instead of dialing them with the LocalDialer
+	Endpoints *Endpoints
+	// HTTPPolicies serves the connections requested by the remote host to the addresses it maps in HTTP mode,
+	// checking every request against the HTTPPolicy of the address, after the connection was allowed.
+	// Addresses are compared ignoring the case and trailing dot of hostnames. Any other address reaching the same
+	// service, such as its IP or another of its names, gets a plain connection: the ConnectAuthorizer or
+	// DialAuthorizer must only allow the services served in HTTP mode through the addresses mapped here.
+	HTTPPolicies map[string]*HTTPPolicy
+	// ProxyProtocol sends a PROXY protocol header to the addresses it maps, dialed on behalf of the remote host,
+	// describing the connection from the source address sent by the remote host, see WithSourceAddress.
+	// Addresses served by Endpoints or HTTPPolicies never get a header, it would reach the in-memory server handling
+	// their connections instead of a backend.
+	ProxyProtocol map[string]ProxyProtocolVersion
+}
+
 func NewClientSession(auth ConnectAuthorizer, conn *websocket.Conn) *Session {
 	return NewClientSessionWithDialer(auth, conn, nil)
 }
 
 func NewClientSessionWithDialer(auth ConnectAuthorizer, conn *websocket.Conn, dialer Dialer) *Session {
-	return &Session{
-		clientKey: "client",
-		conn:      newWSConn(conn),
-		conns:     map[int64]*connection{},
-		auth:      auth,
-		client:    true,
-		dialer:    dialer,
+	return NewClientSessionWithOptions(auth, conn, ClientOptions{LocalDialer: dialer})
+}
+
+func NewClientSessionWithOptions(auth ConnectAuthorizer, conn *websocket.Conn, opts ClientOptions) *Session {
+	s := &Session{
+		// odd IDs, see connIDStep
+		nextConnID:    -1,
+		clientKey:     "client",
+		conn:          newWSConn(conn),
+		conns:         map[int64]*connection{},
+		auth:          auth,
+		client:        true,
+		dialer:        opts.LocalDialer,
+		dialAuth:      opts.DialAuthorizer,
+		listenAuth:    opts.ListenAuthorizer,
+		endpoints:     opts.Endpoints,
+		httpPolicies:  canonicalHTTPPolicies(opts.HTTPPolicies),
+		proxyProtocol: opts.ProxyProtocol,
 	}
+	sessionConfig{
+		metrics:        opts.Metrics,
+		logger:         opts.Logger,
+		tracer:         opts.Tracer,
+		tracerProvider: opts.TracerProvider,
+		propagator:     opts.Propagator,
+		audit:          opts.AuditSink,
+		sessionLimit:   opts.SessionRateLimit,
+		connLimit:      opts.ConnectionRateLimit,
+		connLimits:     opts.ConnectionLimits,
+		budget:         opts.MemoryBudget,
+		idleTimeout:    opts.IdleTimeout,
+		maxPaused:      opts.MaxPausedDuration,
+	}.apply(s)
+	return s
 }
 
 func newSession(sessionKey int64, clientKey string, conn wsConn) *Session {
-	return &Session{
-		nextConnID:       1,
+	s := &Session{
+		nextConnID:       0,
 		clientKey:        clientKey,
 		sessionKey:       sessionKey,
 		conn:             conn,
 		conns:            map[int64]*connection{},
 		remoteClientKeys: map[string]map[int]bool{},
 	}
+	sessionConfig{}.apply(s)
+	return s
+}
+
+func metricsOrDefault(recorder metrics.Recorder) metrics.Recorder {
+	if recorder == nil {
+		return metrics.Default
+	}
+	return recorder
 }
 
 // addConnection safely registers a new connection in the connections map
@@ -90,9 +278,6 @@ func (s *Session) addConnection(connID int64, conn *connection) {
 	defer s.Unlock()
 
 	s.conns[connID] = conn
-	if PrintTunnelData {
-		logrus.Debugf("CONNECTIONS %d %d", s.sessionKey, len(s.conns))
-	}
 }
 
 // removeConnection safely removes a connection by ID, returning the connection object
@@ -100,11 +285,7 @@ func (s *Session) removeConnection(connID int64) *connection {
 	s.Lock()
 	defer s.Unlock()
 
-	conn := s.removeConnectionLocked(connID)
-	if PrintTunnelData {
-		defer logrus.Debugf("CONNECTIONS %d %d", s.sessionKey, len(s.conns))
-	}
-	return conn
+	return s.removeConnectionLocked(connID)
 }
 
 // removeConnectionLocked removes a given connection from the session.
@@ -136,6 +317,19 @@ func (s *Session) activeConnectionIDs() []int64 {
 	return res
 }
 
+// Connections returns the currently active connections for this session, ordered by ID
+func (s *Session) Connections() []Conn {
+	s.RLock()
+	defer s.RUnlock()
+
+	res := make([]Conn, 0, len(s.conns))
+	for _, conn := range s.conns {
+		res = append(res, conn)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID() < res[j].ID() })
+	return res
+}
+
 // addSessionKey registers a new session key for a given client key
 func (s *Session) addSessionKey(clientKey string, sessionKey int) {
 	s.Lock()
@@ -168,17 +362,39 @@ func (s *Session) getSessionKeys(clientKey string) map[int]bool {
 	return s.remoteClientKeys[clientKey]
 }
 
+// listRemoteClients returns a copy of the remote client keys with their session keys
+func (s *Session) listRemoteClients() map[string][]int {
+	s.RLock()
+	defer s.RUnlock()
+
+	res := make(map[string][]int, len(s.remoteClientKeys))
+	for clientKey, keys := range s.remoteClientKeys {
+		for sessionKey := range keys {
+			res[clientKey] = append(res[clientKey], sessionKey)
+		}
+		sort.Ints(res[clientKey])
+	}
+	return res
+}
+
 func (s *Session) startPings(rootCtx context.Context) {
 	ctx, cancel := context.WithCancel(rootCtx)
+	s.Lock()
 	s.pingCancel = cancel
 	s.pingWait.Add(1)
+	s.Unlock()
 
 	go func() {
 		defer s.pingWait.Done()
 
-		t := time.NewTicker(PingWriteInterval)
-		defer t.Stop()
+		var pings <-chan time.Time
+		if s.client || s.pings {
+			t := time.NewTicker(PingWriteInterval)
+			defer t.Stop()
+			pings = t.C
+		}
 
+		// both ends send the list of active connections, so each of them can reclaim the connections closed by the other
 		syncConnections := time.NewTicker(SyncConnectionsInterval)
 		defer syncConnections.Stop()
 
@@ -188,17 +404,20 @@ func (s *Session) startPings(rootCtx context.Context) {
 				return
 			case <-syncConnections.C:
 				if err := s.sendSyncConnections(); err != nil {
-					logrus.WithError(err).Error("Error syncing connections")
+					s.logger.Error("Error syncing connections", "error", err)
 				}
-			case <-t.C:
+			case <-pings:
 				if err := s.sendPing(); err != nil {
-					logrus.WithError(err).Error("Error writing ping")
+					s.logger.Error("Error writing ping", "error", err)
 				}
-				s := ValueFromContext(ctx)
-				if s == "" {
-					s = "<unknown context>"
+				if rtt := s.conn.RTT(); rtt > 0 {
+					s.metrics.SessionRTT(s.clientKey, rtt)
 				}
-				logrus.Tracef("[%s] Wrote ping", s)
+				caller := ValueFromContext(ctx)
+				if caller == "" {
+					caller = "<unknown context>"
+				}
+				s.logger.Log(ctx, LevelTrace, "Wrote ping", "caller", caller)
 			}
 		}
 	}()
@@ -206,22 +425,24 @@ func (s *Session) startPings(rootCtx context.Context) {
 
 // sendPing sends a Ping control message to the peer
 func (s *Session) sendPing() error {
-	return s.conn.WriteControl(websocket.PingMessage, time.Now().Add(PingWaitDuration), []byte(""))
+	now := time.Now()
+	return s.conn.WriteControl(websocket.PingMessage, now.Add(PingWaitDuration), encodePingTime(now))
 }
 
 func (s *Session) stopPings() {
-	if s.pingCancel == nil {
+	s.RLock()
+	pingCancel := s.pingCancel
+	s.RUnlock()
+	if pingCancel == nil {
 		return
 	}
 
-	s.pingCancel()
+	pingCancel()
 	s.pingWait.Wait()
 }
 
 func (s *Session) Serve(ctx context.Context) (int, error) {
-	if s.client {
-		s.startPings(ctx)
-	}
+	s.startPings(ctx)
 
 	for {
 		msType, reader, err := s.conn.NextReader()
@@ -233,6 +454,15 @@ func (s *Session) Serve(ctx context.Context) (int, error) {
 			return 400, errWrongMessageType
 		}
 
+		if c := s.capture.Load(); c != nil {
+			frame, err := io.ReadAll(reader)
+			if err != nil {
+				return 400, err
+			}
+			c.record(s.sessionKey, CaptureRead, frame)
+			reader = bytes.NewReader(frame)
+		}
+
 		if err := s.serveMessage(ctx, reader); err != nil {
 			return 500, err
 		}
@@ -261,25 +491,36 @@ func (s *Session) Dial(ctx context.Context, proto, address string) (net.Conn, er
 	return s.serverConnectContext(ctx, proto, address)
 }
 
-func (s *Session) serverConnectContext(ctx context.Context, proto, address string) (net.Conn, error) {
+func (s *Session) serverConnectContext(ctx context.Context, proto, address string) (_ net.Conn, err error) {
+	ctx, span := s.otelTracer.Start(ctx, SpanDial, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("remotedialer.client_key", s.clientKey),
+		attribute.Int64("remotedialer.session_key", s.sessionKey),
+		attribute.String("network.transport", proto),
+		attribute.String("remotedialer.address", address),
+	))
+	defer func() {
+		endSpan(span, err)
+	}()
+
 	deadline, ok := ctx.Deadline()
 	if ok {
-		return s.serverConnect(deadline, proto, address)
+		return s.serverConnect(ctx, deadline, proto, address)
 	}
 
 	result := make(chan connResult, 1)
 	go func() {
-		c, err := s.serverConnect(defaultDeadline(), proto, address)
+		c, err := s.serverConnect(ctx, defaultDeadline(), proto, address)
 		result <- connResult{conn: c, err: err}
 	}()
 
 	select {
 	case <-ctx.Done():
-		// We don't want to orphan an open connection so we wait for the result and immediately close it
+		// We don't want to orphan an open connection so we wait for the result and immediately abort it,
+		// so the remote end stops dialing
 		go func() {
 			r := <-result
 			if r.err == nil {
-				r.conn.Close()
+				r.conn.(*connection).abort(ErrDialAborted)
 			}
 		}()
 		return nil, ctx.Err()
@@ -288,13 +529,30 @@ func (s *Session) serverConnectContext(ctx context.Context, proto, address strin
 	}
 }
 
-func (s *Session) serverConnect(deadline time.Time, proto, address string) (net.Conn, error) {
-	connID := atomic.AddInt64(&s.nextConnID, 1)
-	conn := newConnection(connID, s, proto, address)
+func (s *Session) serverConnect(ctx context.Context, deadline time.Time, proto, address string) (net.Conn, error) {
+	release, err := s.admit(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	connID := atomic.AddInt64(&s.nextConnID, connIDStep)
+	conn := newConnection(ctx, connID, s, proto, address, true)
+	conn.release = release
+	ctx = s.startConnectionSpan(ctx, conn, trace.SpanKindClient)
 
 	s.addConnection(connID, conn)
 
-	_, err := s.writeMessage(deadline, newConnect(connID, proto, address))
+	var traceContext, metadata map[string]string
+	if s.connectExtension {
+		// older peers would fail to parse the address followed by the extension
+		traceContext, metadata = s.injectTraceContext(ctx), connectMetadata(ctx)
+	}
+	msg, err := newConnect(connID, proto, address, traceContext, metadata)
+	if err == nil {
+		// the remote end gives up dialing when the caller would
+		msg.dialTimeout = time.Until(deadline)
+		_, err = s.writeMessage(deadline, msg)
+	}
 	if err != nil {
 		s.closeConnection(connID, err)
 		return nil, err
@@ -304,14 +562,24 @@ func (s *Session) serverConnect(deadline time.Time, proto, address string) (net.
 }
 
 func (s *Session) writeMessage(deadline time.Time, message *message) (int, error) {
-	if PrintTunnelData {
-		logrus.Debug("WRITE ", message)
+	if c := s.capture.Load(); c != nil {
+		c.record(s.sessionKey, CaptureWrite, message.Bytes())
 	}
-	return message.WriteTo(deadline, s.conn)
+	n, err := message.WriteTo(deadline, s.conn)
+	if err == nil {
+		s.tracer.FrameWritten(s.frameInfo(message, n))
+		if message.messageType == Data {
+			if t, ok := s.tracer.(PayloadTracer); ok && t.SamplePayload(s.frameInfo(message, n)) {
+				t.FramePayload(s.frameInfo(message, n), false, message.bytes)
+			}
+		}
+	}
+	return n, err
 }
 
 func (s *Session) Close() {
 	s.stopPings()
+	s.closeListeners(errors.New("tunnel disconnect"))
 
 	s.Lock()
 	defer s.Unlock()
@@ -322,6 +590,15 @@ func (s *Session) Close() {
 	s.conns = map[int64]*connection{}
 }
 
+// disconnect closes the underlying websocket connection, sending a close frame with the provided reason to the remote end first
+func (s *Session) disconnect(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := s.conn.WriteControl(websocket.CloseMessage, time.Now().Add(SendErrorTimeout), msg); err != nil {
+		s.logger.Warn("Failed to send close message", "error", err)
+	}
+	_ = s.conn.Close()
+}
+
 func (s *Session) sessionAdded(clientKey string, sessionKey int64) {
 	client := fmt.Sprintf("%s/%d", clientKey, sessionKey)
 	_, err := s.writeMessage(time.Time{}, newAddClient(client))
@@ -8,8 +8,6 @@ import (
 	"sync"
 
 	"github.com/gorilla/websocket"
-
-	"github.com/rancher/remotedialer/metrics"
 )
 
 type sessionListener interface {
@@ -22,6 +20,10 @@ type sessionManager struct {
 	clients   map[string][]*Session
 	peers     map[string][]*Session
 	listeners map[sessionListener]bool
+	// cordoned holds the client keys for which no new connections should be routed
+	cordoned map[string]bool
+	// changed is closed and replaced every time the set of reachable clients changes, waking up any waiters
+	changed chan struct{}
 }
 
 func newSessionManager() *sessionManager {
@@ -29,6 +31,8 @@ func newSessionManager() *sessionManager {
 		clients:   map[string][]*Session{},
 		peers:     map[string][]*Session{},
 		listeners: map[sessionListener]bool{},
+		cordoned:  map[string]bool{},
+		changed:   make(chan struct{}),
 	}
 }
 
@@ -81,6 +85,24 @@ func (sm *sessionManager) getDialer(clientKey string) (Dialer, error) {
 	sm.Lock()
 	defer sm.Unlock()
 
+	if sm.cordoned[clientKey] {
+		return nil, fmt.Errorf("client %s is cordoned", clientKey)
+	}
+	return sm.getDialerLocked(clientKey)
+}
+
+// hasSession returns whether a session for the given client key is available, regardless of whether it's cordoned
+func (sm *sessionManager) hasSession(clientKey string) bool {
+	sm.Lock()
+	defer sm.Unlock()
+
+	_, err := sm.getDialerLocked(clientKey)
+	return err == nil
+}
+
+// getDialerLocked returns a Dialer for the first session found for the given client key.
+// The sessionManager lock must be held by the caller when calling this method
+func (sm *sessionManager) getDialerLocked(clientKey string) (Dialer, error) {
 	sessions := sm.clients[clientKey]
 	if len(sessions) > 0 {
 		return toDialer(sessions[0], ""), nil
@@ -98,19 +120,141 @@ func (sm *sessionManager) getDialer(clientKey string) (Dialer, error) {
 	return nil, fmt.Errorf("failed to find Session for client %s", clientKey)
 }
 
-func (sm *sessionManager) add(clientKey string, conn *websocket.Conn, peer bool) *Session {
+// waitForDialer returns a Dialer for the given client key, waiting for a session to be added if none is available yet.
+// Cordoned clients are waited on until uncordoned.
+// It returns an error only if ctx is done before any session for clientKey is found.
+func (sm *sessionManager) waitForDialer(ctx context.Context, clientKey string) (Dialer, error) {
+	for {
+		var (
+			d   Dialer
+			err error
+		)
+		sm.Lock()
+		if sm.cordoned[clientKey] {
+			err = fmt.Errorf("client %s is cordoned", clientKey)
+		} else {
+			d, err = sm.getDialerLocked(clientKey)
+		}
+		changed := sm.changed
+		sm.Unlock()
+		if err == nil {
+			return d, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", err, ctx.Err())
+		case <-changed:
+		}
+	}
+}
+
+// notifyChanged wakes up all the goroutines waiting for a session to be available
+func (sm *sessionManager) notifyChanged() {
+	sm.Lock()
+	defer sm.Unlock()
+
+	sm.notifyChangedLocked()
+}
+
+// notifyChangedLocked wakes up all the goroutines waiting for a session to be available.
+// The sessionManager lock must be held by the caller when calling this method
+func (sm *sessionManager) notifyChangedLocked() {
+	close(sm.changed)
+	sm.changed = make(chan struct{})
+}
+
+// setCordoned marks or unmarks a client key as cordoned, preventing new connections from being routed to it
+func (sm *sessionManager) setCordoned(clientKey string, cordoned bool) {
+	sm.Lock()
+	defer sm.Unlock()
+
+	if cordoned {
+		sm.cordoned[clientKey] = true
+		return
+	}
+	delete(sm.cordoned, clientKey)
+	sm.notifyChangedLocked()
+}
+
+// getSessions returns all the sessions, either from clients or peers, registered for the given client key
+func (sm *sessionManager) getSessions(clientKey string) []*Session {
+	sm.Lock()
+	defer sm.Unlock()
+
+	var res []*Session
+	res = append(res, sm.clients[clientKey]...)
+	res = append(res, sm.peers[clientKey]...)
+	return res
+}
+
+// getClientSession returns the first session of the given client key connected to this server, nil if none
+func (sm *sessionManager) getClientSession(clientKey string) *Session {
+	sm.Lock()
+	defer sm.Unlock()
+
+	if sessions := sm.clients[clientKey]; len(sessions) > 0 {
+		return sessions[0]
+	}
+	return nil
+}
+
+// getSessionByKey returns the session, either from clients or peers, with the given session key
+func (sm *sessionManager) getSessionByKey(sessionKey int64) *Session {
+	sm.Lock()
+	defer sm.Unlock()
+
+	for _, store := range []map[string][]*Session{sm.clients, sm.peers} {
+		for _, sessions := range store {
+			for _, session := range sessions {
+				if session.sessionKey == sessionKey {
+					return session
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// listSessions returns all the registered sessions, split by clients and peers
+func (sm *sessionManager) listSessions() (clients, peers []*Session) {
+	sm.Lock()
+	defer sm.Unlock()
+
+	for _, sessions := range sm.clients {
+		clients = append(clients, sessions...)
+	}
+	for _, sessions := range sm.peers {
+		peers = append(peers, sessions...)
+	}
+	return clients, peers
+}
+
+// isCordoned returns whether the given client key is cordoned
+func (sm *sessionManager) isCordoned(clientKey string) bool {
+	sm.Lock()
+	defer sm.Unlock()
+
+	return sm.cordoned[clientKey]
+}
+
+func (sm *sessionManager) add(clientKey string, conn *websocket.Conn, peer bool, cfg sessionConfig) *Session {
 	sessionKey := rand.Int63()
 	session := newSession(sessionKey, clientKey, newWSConn(conn))
+	cfg.apply(session)
 
 	sm.Lock()
 	defer sm.Unlock()
 
 	if peer {
+		// remote clients announced by peers can be dialed too, so waiters need to be woken up when those change
+		session.remoteClientsChanged = sm.notifyChanged
 		sm.peers[clientKey] = append(sm.peers[clientKey], session)
 	} else {
 		sm.clients[clientKey] = append(sm.clients[clientKey], session)
 	}
-	metrics.IncSMTotalAddWS(clientKey, peer)
+	session.metrics.SessionAdded(clientKey, peer)
+	sm.notifyChangedLocked()
 
 	for l := range sm.listeners {
 		l.sessionAdded(clientKey, session.sessionKey)
@@ -134,7 +278,7 @@ func (sm *sessionManager) remove(s *Session) {
 				} else {
 					isPeer = true
 				}
-				metrics.IncSMTotalRemoveWS(s.clientKey, isPeer)
+				s.metrics.SessionRemoved(s.clientKey, isPeer)
 				continue
 			}
 			newSessions = append(newSessions, v)
@@ -0,0 +1,87 @@
+package remotedialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSessionManager_waitForDialer(t *testing.T) {
+	t.Parallel()
+
+	sm := newSessionManager()
+	clientKey := "wait-test"
+
+	result := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := sm.waitForDialer(ctx, clientKey)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		t.Fatalf("waitForDialer returned before a session was added: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	session := sm.add(clientKey, testServerWS(t, nil), false, sessionConfig{})
+	defer sm.remove(session)
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("waitForDialer did not return after a session was added")
+	}
+}
+
+func TestSessionManager_waitForDialerRemoteClient(t *testing.T) {
+	t.Parallel()
+
+	sm := newSessionManager()
+	clientKey := "remote-wait-test"
+
+	peerSession := sm.add("peer", testServerWS(t, nil), true, sessionConfig{})
+	defer sm.remove(peerSession)
+
+	result := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := sm.waitForDialer(ctx, clientKey)
+		result <- err
+	}()
+
+	if err := peerSession.addRemoteClient(fmt.Sprintf("%s/%d", clientKey, rand.Int())); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("waitForDialer did not return after a remote client was added")
+	}
+}
+
+func TestSessionManager_waitForDialerTimeout(t *testing.T) {
+	t.Parallel()
+
+	sm := newSessionManager()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := sm.waitForDialer(ctx, "missing"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error, got: %v, want: %v", err, context.DeadlineExceeded)
+	}
+}
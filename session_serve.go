@@ -1,12 +1,14 @@
 package remotedialer
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"time"
 
-	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // serveMessage accepts an incoming message from the underlying websocket connection and processes the request based on its messageType
@@ -16,9 +18,12 @@ func (s *Session) serveMessage(ctx context.Context, reader io.Reader) error {
 		return err
 	}
 
-	if PrintTunnelData {
-		logrus.Debug("REQUEST ", message)
-	}
+	// count the body bytes consumed while processing the message, so they can be traced afterward
+	body := &countingReader{Reader: message.body}
+	message.body = body
+	defer func() {
+		s.tracer.FrameRead(s.frameInfo(message, len(message.bytes)+body.n))
+	}()
 
 	switch message.messageType {
 	case Connect:
@@ -29,25 +34,57 @@ func (s *Session) serveMessage(ctx context.Context, reader io.Reader) error {
 		return s.removeRemoteClient(message.address)
 	case SyncConnections:
 		return s.syncConnections(message.body)
+	case SyncConnectionRanges:
+		return s.syncConnectionRanges(message.body)
 	case Data:
-		s.connectionData(message.connID, message.body)
+		s.connectionData(message)
 	case Pause:
 		s.pauseConnection(message.connID)
 	case Resume:
 		s.resumeConnection(message.connID)
+	case Listen:
+		s.listen(ctx, message)
+	case ListenReady:
+		s.listenerReady(message)
+	case ListenAccept:
+		s.listenerAccept(ctx, message)
 	case Error:
 		s.closeConnection(message.connID, message.Err())
+		s.closeListener(message.connID, message.Err())
 	}
 	return nil
 }
 
 // clientConnect accepts a new connection request, dialing back to establish the connection
 func (s *Session) clientConnect(ctx context.Context, message *message) error {
-	if s.auth == nil || !s.auth(message.proto, message.address) {
-		return errors.New("connect not allowed")
+	// only the requested connection is rejected, the session remains usable by the remote end
+	if !s.authorize(message) {
+		s.logger.Debug("Connect not allowed", "proto", message.proto, "address", message.address)
+		_, _ = s.writeMessage(time.Now().Add(SendErrorTimeout), newErrorMessage(message.connID, ErrDialForbidden))
+		return nil
+	}
+	if s.getConnection(message.connID) != nil {
+		// the remote end allocated an ID already used by a connection dialed by this end
+		err := fmt.Errorf("connection ID %d already in use", message.connID)
+		_, _ = s.writeMessage(time.Now().Add(SendErrorTimeout), newErrorMessage(message.connID, err))
+		return nil
+	}
+
+	release, err := s.admit(ctx, false)
+	if err != nil {
+		// only this connection is rejected, the remote end can retry it later
+		_, _ = s.writeMessage(time.Now().Add(SendErrorTimeout), newErrorMessage(message.connID, err))
+		return nil
 	}
 
-	conn := newConnection(message.connID, s, message.proto, message.address)
+	ctx = WithDialMetadata(s.extractTraceContext(ctx, message), message.metadata)
+	if caller := message.metadata[DialMetadataCaller]; caller != "" {
+		// the caller on the remote end, recorded by this end too
+		ctx = context.WithValue(ctx, ContextKeyCaller, caller)
+	}
+	conn := newConnection(ctx, message.connID, s, message.proto, message.address, false)
+	conn.release = release
+	ctx = s.startConnectionSpan(ctx, conn, trace.SpanKindServer)
 	s.addConnection(message.connID, conn)
 
 	go clientDial(ctx, s.dialer, conn, message)
@@ -66,9 +103,8 @@ func (s *Session) addRemoteClient(address string) error {
 		return fmt.Errorf("invalid remote Session %s: %v", address, err)
 	}
 	s.addSessionKey(clientKey, sessionKey)
-
-	if PrintTunnelData {
-		logrus.Debugf("ADD REMOTE CLIENT %s, SESSION %d", address, s.sessionKey)
+	if s.remoteClientsChanged != nil {
+		s.remoteClientsChanged()
 	}
 
 	return nil
@@ -82,14 +118,10 @@ func (s *Session) removeRemoteClient(address string) error {
 	}
 	s.removeSessionKey(clientKey, sessionKey)
 
-	if PrintTunnelData {
-		logrus.Debugf("REMOVE REMOTE CLIENT %s, SESSION %d", address, s.sessionKey)
-	}
-
 	return nil
 }
 
-// syncConnections closes any session connection that is not present in the IDs received from the client
+// syncConnections closes any session connection that is not present in the IDs received from the remote end
 func (s *Session) syncConnections(r io.Reader) error {
 	payload, err := io.ReadAll(r)
 	if err != nil {
@@ -104,6 +136,25 @@ func (s *Session) syncConnections(r io.Reader) error {
 	return nil
 }
 
+// syncConnectionRanges closes any session connection that is not present in the ranges of IDs received from the remote end
+func (s *Session) syncConnectionRanges(r io.Reader) error {
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("reading message body: %w", err)
+	}
+	ranges, err := decodeConnectionRanges(payload)
+	if errors.Is(err, errUnknownSyncVersion) {
+		// sent by a newer peer, ignored until this end is updated
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("decoding sync connection ranges payload: %w", err)
+	}
+
+	s.remoteSyncRanges.Store(true)
+	s.compareAndCloseStaleConnectionRanges(ranges)
+	return nil
+}
+
 // closeConnection removes a connection for a given ID from the session, sending an error message to communicate the closing to the other end.
 // If an error is not provided, io.EOF will be used instead.
 func (s *Session) closeConnection(connID int64, err error) {
@@ -113,14 +164,25 @@ func (s *Session) closeConnection(connID int64, err error) {
 }
 
 // connectionData process incoming data from connection by reading the body into an internal readBuffer
-func (s *Session) connectionData(connID int64, body io.Reader) {
+func (s *Session) connectionData(message *message) {
+	connID, body := message.connID, message.body
 	conn := s.getConnection(connID)
 	if conn == nil {
 		errMsg := newErrorMessage(connID, fmt.Errorf("connection not found %s/%d/%d", s.clientKey, s.sessionKey, connID))
-		_, _ = errMsg.WriteTo(defaultDeadline(), s.conn)
+		_, _ = s.writeMessage(defaultDeadline(), errMsg)
 		return
 	}
 
+	if t, ok := s.tracer.(PayloadTracer); ok && t.SamplePayload(s.frameInfo(message, 0)) {
+		payload, err := io.ReadAll(body)
+		if err != nil {
+			s.closeConnection(connID, err)
+			return
+		}
+		t.FramePayload(s.frameInfo(message, len(payload)), true, payload)
+		body = bytes.NewReader(payload)
+	}
+
 	if err := conn.OnData(body); err != nil {
 		s.closeConnection(connID, err)
 	}
@@ -139,3 +201,15 @@ func (s *Session) resumeConnection(connID int64) {
 		conn.OnResume()
 	}
 }
+
+// countingReader is an io.Reader keeping track of the number of bytes read
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
@@ -29,7 +29,11 @@ func TestSession_clientConnect(t *testing.T) {
 	}
 
 	connID := getDummyConnectionID()
-	if err := s.clientConnect(ctx, newConnect(connID, msgProto, msgAddr)); err != nil {
+	connect, err := newConnect(connID, msgProto, msgAddr, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.clientConnect(ctx, connect); err != nil {
 		t.Fatal(err)
 	}
 
@@ -69,11 +73,11 @@ func TestSession_addRemoveRemoteClient(t *testing.T) {
 func TestSession_connectionData(t *testing.T) {
 	s := setupDummySession(t, 0)
 	connID := getDummyConnectionID()
-	conn := newConnection(connID, s, "test", "test")
+	conn := newConnection(context.Background(), connID, s, "test", "test", false)
 	s.addConnection(connID, conn)
 
 	data := "testing!"
-	s.connectionData(connID, strings.NewReader(data))
+	s.connectionData(&message{connID: connID, messageType: Data, body: strings.NewReader(data)})
 
 	if got, want := conn.buffer.offerCount, int64(len(data)); got != want {
 		t.Errorf("incorrect data length, got %d, want %d", got, want)
@@ -91,7 +95,7 @@ func TestSession_connectionData(t *testing.T) {
 func TestSession_pauseResumeConnection(t *testing.T) {
 	s := setupDummySession(t, 0)
 	connID := getDummyConnectionID()
-	conn := newConnection(connID, s, "test", "test")
+	conn := newConnection(context.Background(), connID, s, "test", "test", false)
 	s.addConnection(connID, conn)
 
 	s.pauseConnection(connID)
@@ -118,7 +122,7 @@ func TestSession_closeConnection(t *testing.T) {
 		},
 	}
 	connID := getDummyConnectionID()
-	conn := newConnection(connID, s, "test", "test")
+	conn := newConnection(context.Background(), connID, s, "test", "test", false)
 	s.addConnection(connID, conn)
 
 	// Ensure Error message is sent regardless of the WriteDeadline value, see https://github.com/rancher/remotedialer/pull/79
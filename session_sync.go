@@ -1,13 +1,14 @@
 package remotedialer
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"time"
 )
 
-var errCloseSyncConnections = errors.New("sync from client")
+var errCloseSyncConnections = errors.New("connection not active on the remote end")
 
 // encodeConnectionIDs serializes a slice of connection IDs
 func encodeConnectionIDs(ids []int64) []byte {
@@ -39,30 +40,129 @@ func newSyncConnectionsMessage(connectionIDs []int64) *message {
 	}
 }
 
-// sendSyncConnections sends a binary message of type SyncConnections, whose payload is a list of the active connection IDs for this session
+// syncConnectionRangesVersion is the first byte of SyncConnectionRanges payloads, identifying their encoding
+const syncConnectionRangesVersion = 1
+
+var errUnknownSyncVersion = errors.New("unknown sync connection ranges version")
+
+// connectionRange is a range of consecutive connection IDs, both ends included
+type connectionRange struct {
+	first, last int64
+}
+
+// encodeConnectionRanges serializes a sorted slice of connection IDs as ranges of consecutive IDs.
+// After the version byte, every range is encoded as the varint difference between its first ID and the last ID
+// of the previous range (0 for the first range), followed by the uvarint number of IDs following the first one.
+func encodeConnectionRanges(ids []int64) []byte {
+	payload := []byte{syncConnectionRangesVersion}
+	var prev int64
+	for i := 0; i < len(ids); {
+		j := i
+		for j+1 < len(ids) && ids[j+1] == ids[j]+1 {
+			j++
+		}
+		payload = binary.AppendVarint(payload, ids[i]-prev)
+		payload = binary.AppendUvarint(payload, uint64(j-i))
+		prev = ids[j]
+		i = j + 1
+	}
+	return payload
+}
+
+// decodeConnectionRanges deserializes the ranges of connection IDs encoded by encodeConnectionRanges
+func decodeConnectionRanges(payload []byte) ([]connectionRange, error) {
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("incorrect data format")
+	}
+	if payload[0] != syncConnectionRangesVersion {
+		return nil, errUnknownSyncVersion
+	}
+
+	r := bytes.NewReader(payload[1:])
+	var (
+		res  []connectionRange
+		prev int64
+	)
+	for r.Len() > 0 {
+		delta, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect data format: %w", err)
+		}
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect data format: %w", err)
+		}
+		cr := connectionRange{first: prev + delta}
+		cr.last = cr.first + int64(length)
+		if (len(res) > 0 && cr.first <= prev) || cr.last < cr.first {
+			return nil, fmt.Errorf("incorrect data format: unsorted ranges")
+		}
+		res = append(res, cr)
+		prev = cr.last
+	}
+	return res, nil
+}
+
+func newSyncConnectionRangesMessage(connectionIDs []int64) *message {
+	return &message{
+		id:          nextid(),
+		messageType: SyncConnectionRanges,
+		bytes:       encodeConnectionRanges(connectionIDs),
+	}
+}
+
+// sendSyncConnections sends the list of the active connection IDs for this session in a SyncConnectionRanges message.
+// Clients also send it in a legacy SyncConnections message, until the remote end proves it supports the compact form by sending one.
 func (s *Session) sendSyncConnections() error {
-	_, err := s.writeMessage(time.Now().Add(SyncConnectionsTimeout), newSyncConnectionsMessage(s.activeConnectionIDs()))
-	return err
+	ids := s.activeConnectionIDs()
+	deadline := time.Now().Add(SyncConnectionsTimeout)
+	if _, err := s.writeMessage(deadline, newSyncConnectionRangesMessage(ids)); err != nil {
+		return err
+	}
+	if s.client && !s.remoteSyncRanges.Load() {
+		_, err := s.writeMessage(deadline, newSyncConnectionsMessage(ids))
+		return err
+	}
+	return nil
 }
 
-// compareAndCloseStaleConnections compares the Session's activeConnectionIDs with the provided list from the client, then closing every connection not present in it
-func (s *Session) compareAndCloseStaleConnections(clientIDs []int64) {
-	serverIDs := s.activeConnectionIDs()
-	toClose := diffSortedSetsGetRemoved(serverIDs, clientIDs)
-	if len(toClose) == 0 {
+// compareAndCloseStaleConnections compares the Session's activeConnectionIDs with the provided list from the remote end, then closing every connection not present in it
+func (s *Session) compareAndCloseStaleConnections(remoteIDs []int64) {
+	s.closeStaleConnections(diffSortedSetsGetRemoved(s.activeConnectionIDs(), remoteIDs))
+}
+
+// compareAndCloseStaleConnectionRanges is the same as compareAndCloseStaleConnections, for a list of ranges from the remote end
+func (s *Session) compareAndCloseStaleConnectionRanges(remoteRanges []connectionRange) {
+	s.closeStaleConnections(diffSortedRangesGetRemoved(s.activeConnectionIDs(), remoteRanges))
+}
+
+// closeStaleConnections closes the connections no longer active on the remote end.
+// Recent connections are kept, as the remote end may not have known them yet when it sent its list.
+func (s *Session) closeStaleConnections(ids []int64) {
+	if len(ids) == 0 {
 		return
 	}
 
+	var closed []int64
 	s.Lock()
-	defer s.Unlock()
-	for _, id := range toClose {
-		// Connection no longer active in the client, close it server-side
-		conn := s.removeConnectionLocked(id)
+	for _, id := range ids {
+		conn := s.conns[id]
+		if conn != nil && time.Since(conn.created) < SyncConnectionsTimeout {
+			continue
+		}
+		s.removeConnectionLocked(id)
 		if conn != nil {
 			// Using doTunnelClose directly instead of tunnelClose, omitting unnecessarily sending an Error message
 			conn.doTunnelClose(errCloseSyncConnections)
+			closed = append(closed, id)
 		}
 	}
+	s.Unlock()
+
+	if len(closed) > 0 {
+		s.metrics.StaleConnectionsClosed(s.clientKey, len(closed))
+		s.logger.Info("Closed connections no longer active on the remote end", "count", len(closed), "connIDs", closed[:min(len(closed), 10)])
+	}
 }
 
 // diffSortedSetsGetRemoved compares two sorted slices and returns those items present in a that are not present in b
@@ -84,3 +184,18 @@ func diffSortedSetsGetRemoved(a, b []int64) []int64 {
 	res = append(res, a[i:]...) // any remainders in "a" are also removed from "b"
 	return res
 }
+
+// diffSortedRangesGetRemoved returns the items of the sorted slice a not included in any of the sorted ranges b
+func diffSortedRangesGetRemoved(a []int64, b []connectionRange) []int64 {
+	var res []int64
+	var j int
+	for _, id := range a {
+		for j < len(b) && b[j].last < id {
+			j++
+		}
+		if j == len(b) || id < b[j].first {
+			res = append(res, id)
+		}
+	}
+	return res
+}
@@ -3,6 +3,7 @@ package remotedialer
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -11,6 +12,7 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -91,6 +93,23 @@ func TestSession_sendSyncConnections(t *testing.T) {
 	data := make(chan []byte)
 	conn := testServerWS(t, data)
 	session := newSession(rand.Int63(), "sync-test", newWSConn(conn))
+	session.client = true
+
+	readPayload := func(want messageType) []byte {
+		t.Helper()
+		message, err := newServerMessage(bytes.NewBuffer(<-data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, err := io.ReadAll(message.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := message.messageType; got != want {
+			t.Errorf("incorrect message type, got: %v, want: %v", got, want)
+		}
+		return payload
+	}
 
 	for _, n := range []int{0, 5, 20} {
 		ids := generateIDs(n)
@@ -99,26 +118,118 @@ func TestSession_sendSyncConnections(t *testing.T) {
 		}
 		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
 
-		if err := session.sendSyncConnections(); err != nil {
-			t.Fatal(err)
-		}
-		message, err := newServerMessage(bytes.NewBuffer(<-data))
+		errs := make(chan error, 1)
+		go func() {
+			errs <- session.sendSyncConnections()
+		}()
+		ranges, err := decodeConnectionRanges(readPayload(SyncConnectionRanges))
 		if err != nil {
 			t.Fatal(err)
 		}
-		payload, err := io.ReadAll(message.body)
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		if got, want := message.messageType, SyncConnections; got != want {
-			t.Errorf("incorrect message type, got: %v, want: %v", got, want)
+		if got := diffSortedRangesGetRemoved(session.activeConnectionIDs(), ranges); len(got) > 0 {
+			t.Errorf("connection IDs missing from the ranges: %v", got)
 		}
-		if decoded, err := decodeConnectionIDs(payload); err != nil {
+		// the remote end is not known to support ranges yet
+		if decoded, err := decodeConnectionIDs(readPayload(SyncConnections)); err != nil {
 			t.Fatal(err)
 		} else if got, want := decoded, session.activeConnectionIDs(); !reflect.DeepEqual(got, want) {
 			t.Errorf("incorrect connections IDs, got: %v, want: %v", got, want)
 		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	session.remoteSyncRanges.Store(true)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- session.sendSyncConnections()
+	}()
+	readPayload(SyncConnectionRanges)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-data:
+		t.Error("legacy SyncConnections message sent to a remote end supporting ranges")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func Test_encodeConnectionRanges(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		ids  []int64
+		want []connectionRange
+	}{
+		{name: "empty"},
+		{name: "single", ids: []int64{7}, want: []connectionRange{{7, 7}}},
+		{name: "consecutive", ids: []int64{1, 2, 3, 4}, want: []connectionRange{{1, 4}}},
+		{name: "gaps", ids: []int64{-3, -2, 5, 9, 10, 11}, want: []connectionRange{{-3, -2}, {5, 5}, {9, 11}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			decoded, err := decodeConnectionRanges(encodeConnectionRanges(tt.ids))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decoded; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected ranges, got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+
+	ids := make([]int64, 1000)
+	for x := range ids {
+		ids[x] = int64(x + 1)
+	}
+	if got := len(encodeConnectionRanges(ids)); got > 8 {
+		t.Errorf("consecutive IDs should be encoded in a few bytes, got %d", got)
+	}
+
+	if _, err := decodeConnectionRanges([]byte{syncConnectionRangesVersion + 1, 2}); !errors.Is(err, errUnknownSyncVersion) {
+		t.Errorf("expected unknown versions to be reported, got: %v", err)
+	}
+	if _, err := decodeConnectionRanges([]byte{syncConnectionRangesVersion, 10, 0, 1, 0}); err == nil {
+		t.Error("expected unsorted ranges to be rejected")
+	}
+}
+
+func Test_diffSortedRangesGetRemoved(t *testing.T) {
+	t.Parallel()
+	ranges := []connectionRange{{2, 4}, {8, 8}}
+	if got, want := diffSortedRangesGetRemoved([]int64{1, 2, 4, 5, 8, 9}, ranges), []int64{1, 5, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected result, got: %v, want: %v", got, want)
+	}
+}
+
+func TestSession_syncConnectionRanges(t *testing.T) {
+	t.Parallel()
+
+	s := setupDummySession(t, 0)
+	s.conn = &fakeWSConn{
+		writeMessageCallback: func(int, time.Time, []byte) error {
+			return nil
+		},
+	}
+	stale, active, recent := int64(1), int64(2), int64(3)
+	for _, id := range []int64{stale, active} {
+		s.addConnection(id, newConnection(context.Background(), id, s, "tcp", "test", true))
+		// created before the remote end listed its connections
+		s.getConnection(id).created = time.Now().Add(-2 * SyncConnectionsTimeout)
+	}
+	s.addConnection(recent, newConnection(context.Background(), recent, s, "tcp", "test", true))
+
+	if err := s.syncConnectionRanges(bytes.NewReader(encodeConnectionRanges([]int64{active}))); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.activeConnectionIDs(), []int64{active, recent}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected connections after sync, got: %v, want: %v", got, want)
+	}
+	if !s.remoteSyncRanges.Load() {
+		t.Error("the remote end should be known to support ranges")
 	}
 }
 
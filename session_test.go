@@ -158,6 +158,10 @@ func TestSession_sendPings(t *testing.T) {
 			t.Errorf("ping %d not received in time", i)
 		}
 	}
+
+	if rtt := session.conn.RTT(); rtt <= 0 {
+		t.Errorf("round trip time was not measured, got: %v", rtt)
+	}
 }
 
 // This test is to ensure that there is no deadlock if Close()
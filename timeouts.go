@@ -0,0 +1,81 @@
+package remotedialer
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrIdleTimeout is the error closing connections without traffic for longer than the configured IdleTimeout
+	ErrIdleTimeout = errors.New("connection idle timeout")
+	// ErrPausedTooLong is the error closing connections paused for longer than the configured MaxPausedDuration
+	ErrPausedTooLong = errors.New("connection paused for too long")
+)
+
+// Connection timeout reasons, as reported in metrics
+const (
+	timeoutReasonIdle   = "idle"
+	timeoutReasonPaused = "paused"
+)
+
+// startIdleTimer checks the connection activity once timeout has elapsed
+func (c *connection) startIdleTimer(timeout time.Duration) {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+	c.idleTimer = time.AfterFunc(timeout, c.checkIdle)
+}
+
+// checkIdle closes the connection if it had no traffic during the idle timeout, or checks again when it would expire
+func (c *connection) checkIdle() {
+	idle := time.Since(time.Unix(0, c.lastActivity.Load()))
+	if remaining := c.session.idleTimeout - idle; remaining > 0 {
+		c.startIdleTimer(remaining)
+		return
+	}
+	c.timedOut(timeoutReasonIdle, ErrIdleTimeout)
+}
+
+// active records traffic on the connection, postponing its idle timeout
+func (c *connection) active() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (c *connection) timedOut(reason string, err error) {
+	if c.Err() != nil {
+		return
+	}
+	c.logger.Info("Closing connection", "reason", err)
+	c.session.metrics.ConnectionTimedOut(c.session.clientKey, reason)
+	c.abort(err)
+}
+
+// startPauseTimer closes the connection if it stays paused longer than MaxPausedDuration.
+// The back pressure lock must be held by the caller.
+func (b *backPressure) startPauseTimer() {
+	maxPaused := b.c.session.maxPaused
+	if maxPaused <= 0 {
+		return
+	}
+	b.stopPauseTimer()
+	b.pausedSince = time.Now()
+	b.pauseTimer = time.AfterFunc(maxPaused, func() {
+		b.cond.L.Lock()
+		expired := b.paused && !b.closed && time.Since(b.pausedSince) >= maxPaused
+		b.cond.L.Unlock()
+		if expired {
+			b.c.timedOut(timeoutReasonPaused, ErrPausedTooLong)
+		}
+	})
+}
+
+// stopPauseTimer stops the timer started by startPauseTimer, the back pressure lock must be held by the caller
+func (b *backPressure) stopPauseTimer() {
+	if b.pauseTimer != nil {
+		b.pauseTimer.Stop()
+		b.pauseTimer = nil
+	}
+}
@@ -0,0 +1,94 @@
+package remotedialer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConnectionIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	s := setupDummySession(t, 0)
+	s.conn = &fakeWSConn{
+		writeMessageCallback: func(int, time.Time, []byte) error {
+			return nil
+		},
+	}
+	s.idleTimeout = 200 * time.Millisecond
+	connID := getDummyConnectionID()
+	conn := newConnection(context.Background(), connID, s, "tcp", "test", true)
+	s.addConnection(connID, conn)
+
+	// traffic postpones the timeout
+	for range 4 {
+		time.Sleep(100 * time.Millisecond)
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.Err(); err != nil {
+		t.Fatalf("active connection was closed: %v", err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, ErrIdleTimeout) {
+		t.Errorf("expected the connection to be closed with ErrIdleTimeout, got: %v", err)
+	}
+	if s.getConnection(connID) != nil {
+		t.Error("expected the idle connection to be removed from the session")
+	}
+}
+
+func TestConnectionMaxPausedDuration(t *testing.T) {
+	t.Parallel()
+
+	s := setupDummySession(t, 0)
+	s.conn = &fakeWSConn{
+		writeMessageCallback: func(int, time.Time, []byte) error {
+			return nil
+		},
+	}
+	s.maxPaused = 200 * time.Millisecond
+	connID := getDummyConnectionID()
+	conn := newConnection(context.Background(), connID, s, "tcp", "test", true)
+	s.addConnection(connID, conn)
+
+	// resuming in time keeps the connection open
+	conn.OnPause()
+	time.Sleep(100 * time.Millisecond)
+	conn.OnResume()
+	time.Sleep(200 * time.Millisecond)
+	if err := conn.Err(); err != nil {
+		t.Fatalf("resumed connection was closed: %v", err)
+	}
+
+	conn.OnPause()
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		_, _ = conn.Write([]byte("blocked"))
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the blocked writer was not released")
+	}
+	if err := conn.Err(); !errors.Is(err, ErrPausedTooLong) {
+		t.Errorf("expected the connection to be closed with ErrPausedTooLong, got: %v", err)
+	}
+}
+
+func TestErrorMessageKnownErrors(t *testing.T) {
+	for _, want := range knownErrors {
+		msg, err := newServerMessage(bytes.NewReader(newErrorMessage(1, want).Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := msg.Err(); !errors.Is(got, want) {
+			t.Errorf("expected %v to be recognized, got: %v", want, got)
+		}
+	}
+}
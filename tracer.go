@@ -0,0 +1,210 @@
+package remotedialer
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"regexp"
+)
+
+// Tracer receives the events happening in a Session.
+// Only metadata is provided, implement PayloadTracer to inspect the data sent through the tunnel.
+// Callbacks are called synchronously from the session goroutines, so implementations must be fast and concurrency-safe.
+type Tracer interface {
+	// FrameWritten is called after a message is sent to the remote end
+	FrameWritten(frame FrameInfo)
+	// FrameRead is called after a message from the remote end is processed
+	FrameRead(frame FrameInfo)
+	// ConnectionOpened is called when a new connection is registered in the session
+	ConnectionOpened(conn ConnInfo)
+	// ConnectionPaused is called when data transfer is paused for a connection.
+	// local is true if this end requested the pause, false if it was requested by the remote end.
+	ConnectionPaused(conn ConnInfo, local bool)
+	// ConnectionResumed is called when data transfer is resumed for a previously paused connection.
+	// local is true if this end requested the resume, false if it was requested by the remote end.
+	ConnectionResumed(conn ConnInfo, local bool)
+	// ConnectionClosed is called once, when a connection is closed
+	ConnectionClosed(conn ConnInfo, err error)
+}
+
+// PayloadTracer is a Tracer that can also inspect the payload of Data messages
+type PayloadTracer interface {
+	Tracer
+	// SamplePayload reports whether FramePayload should be called for the given Data message
+	SamplePayload(frame FrameInfo) bool
+	// FramePayload is called with the payload of a Data message, which must not be retained or modified
+	FramePayload(frame FrameInfo, read bool, payload []byte)
+}
+
+// FrameInfo holds the metadata of a message
+type FrameInfo struct {
+	ClientKey  string
+	SessionKey int64
+	MessageID  int64
+	ConnID     int64
+	Type       string
+	// Size is the size of the message payload in bytes
+	Size int
+	// Proto and Address are only set for Connect messages
+	Proto, Address string
+}
+
+// ConnInfo identifies a tunneled connection
+type ConnInfo struct {
+	ClientKey  string
+	SessionKey int64
+	ConnID     int64
+	Proto      string
+	Address    string
+}
+
+func (s *Session) frameInfo(m *message, size int) FrameInfo {
+	frame := FrameInfo{
+		ClientKey:  s.clientKey,
+		SessionKey: s.sessionKey,
+		MessageID:  m.id,
+		ConnID:     m.connID,
+		Type:       m.messageType.String(),
+		Size:       size,
+	}
+	if m.messageType == Connect {
+		frame.Proto, frame.Address = m.proto, m.address
+	}
+	return frame
+}
+
+func (c *connection) info() ConnInfo {
+	return ConnInfo{
+		ClientKey:  c.session.clientKey,
+		SessionKey: c.session.sessionKey,
+		ConnID:     c.connID,
+		Proto:      c.addr.proto,
+		Address:    c.addr.address,
+	}
+}
+
+// defaultTracer returns the Tracer used when none is provided
+func defaultTracer(logger *slog.Logger) Tracer {
+	if PrintTunnelData {
+		return NewLogTracer(logger)
+	}
+	return noopTracer{}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) FrameWritten(FrameInfo)           {}
+func (noopTracer) FrameRead(FrameInfo)              {}
+func (noopTracer) ConnectionOpened(ConnInfo)        {}
+func (noopTracer) ConnectionPaused(ConnInfo, bool)  {}
+func (noopTracer) ConnectionResumed(ConnInfo, bool) {}
+func (noopTracer) ConnectionClosed(ConnInfo, error) {}
+
+// LogTracer is a Tracer logging the metadata of every event at debug level
+type LogTracer struct {
+	logger *slog.Logger
+}
+
+// NewLogTracer creates a LogTracer writing to the provided logger. The global logrus logger is used if nil.
+func NewLogTracer(logger *slog.Logger) *LogTracer {
+	return &LogTracer{logger: loggerOrDefault(logger)}
+}
+
+func (t *LogTracer) FrameWritten(frame FrameInfo) {
+	t.logFrame("WRITE", frame)
+}
+
+func (t *LogTracer) FrameRead(frame FrameInfo) {
+	t.logFrame("REQUEST", frame)
+}
+
+func (t *LogTracer) ConnectionOpened(conn ConnInfo) {
+	t.logConn("OPENED", conn)
+}
+
+func (t *LogTracer) ConnectionPaused(conn ConnInfo, local bool) {
+	t.logConn("PAUSED", conn, "local", local)
+}
+
+func (t *LogTracer) ConnectionResumed(conn ConnInfo, local bool) {
+	t.logConn("RESUMED", conn, "local", local)
+}
+
+func (t *LogTracer) ConnectionClosed(conn ConnInfo, err error) {
+	t.logConn("CLOSED", conn, "error", err)
+}
+
+func (t *LogTracer) logFrame(msg string, frame FrameInfo) {
+	if !t.logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	attrs := []any{
+		LogKeyClientKey, frame.ClientKey,
+		LogKeySessionKey, frame.SessionKey,
+		LogKeyConnID, frame.ConnID,
+		"messageID", frame.MessageID,
+		"type", frame.Type,
+		"size", frame.Size,
+	}
+	if frame.Address != "" {
+		attrs = append(attrs, "proto", frame.Proto, "address", frame.Address)
+	}
+	t.logger.Debug(msg, attrs...)
+}
+
+func (t *LogTracer) logConn(msg string, conn ConnInfo, extra ...any) {
+	if !t.logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	attrs := append([]any{
+		LogKeyClientKey, conn.ClientKey,
+		LogKeySessionKey, conn.SessionKey,
+		LogKeyConnID, conn.ConnID,
+		"proto", conn.Proto,
+		"address", conn.Address,
+	}, extra...)
+	t.logger.Debug("CONNECTION "+msg, attrs...)
+}
+
+// PayloadSampler is a PayloadTracer logging a redacted sample of the Data payloads, meant for debugging only.
+// Every other event is forwarded to the embedded Tracer, which must not be nil.
+type PayloadSampler struct {
+	Tracer
+	// Logger receives the sampled payloads. The global logrus logger is used if nil.
+	Logger *slog.Logger
+	// Rate is the fraction of Data messages sampled, between 0 and 1
+	Rate float64
+	// MaxBytes limits the number of bytes logged for every payload. Payloads are not truncated if zero.
+	MaxBytes int
+	// Redact transforms the payload before being logged. RedactCredentials is used if nil.
+	Redact func(payload []byte) []byte
+}
+
+func (p *PayloadSampler) SamplePayload(FrameInfo) bool {
+	return p.Rate > 0 && rand.Float64() < p.Rate
+}
+
+func (p *PayloadSampler) FramePayload(frame FrameInfo, read bool, payload []byte) {
+	if p.MaxBytes > 0 && len(payload) > p.MaxBytes {
+		payload = payload[:p.MaxBytes]
+	}
+	redact := p.Redact
+	if redact == nil {
+		redact = RedactCredentials
+	}
+	loggerOrDefault(p.Logger).Debug("PAYLOAD",
+		LogKeyClientKey, frame.ClientKey,
+		LogKeySessionKey, frame.SessionKey,
+		LogKeyConnID, frame.ConnID,
+		"read", read,
+		"size", frame.Size,
+		"payload", string(redact(payload)),
+	)
+}
+
+var credentialsPattern = regexp.MustCompile(`(?im)^((?:proxy-)?authorization|cookie|set-cookie|x-api-key|x-api-tunnel-token)(\s*:\s*)[^\r\n]*`)
+
+// RedactCredentials masks the value of HTTP headers commonly used to carry credentials, such as Authorization or Cookie
+func RedactCredentials(payload []byte) []byte {
+	return credentialsPattern.ReplaceAll(payload, []byte("${1}${2}[REDACTED]"))
+}
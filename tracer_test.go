@@ -0,0 +1,136 @@
+package remotedialer
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingTracer struct {
+	sync.Mutex
+	written, read   []FrameInfo
+	opened, closed  []ConnInfo
+	payloadsWritten [][]byte
+}
+
+func (r *recordingTracer) FrameWritten(frame FrameInfo) {
+	r.Lock()
+	defer r.Unlock()
+	r.written = append(r.written, frame)
+}
+
+func (r *recordingTracer) FrameRead(frame FrameInfo) {
+	r.Lock()
+	defer r.Unlock()
+	r.read = append(r.read, frame)
+}
+
+func (r *recordingTracer) ConnectionOpened(conn ConnInfo) {
+	r.Lock()
+	defer r.Unlock()
+	r.opened = append(r.opened, conn)
+}
+
+func (r *recordingTracer) ConnectionPaused(ConnInfo, bool)  {}
+func (r *recordingTracer) ConnectionResumed(ConnInfo, bool) {}
+
+func (r *recordingTracer) ConnectionClosed(conn ConnInfo, _ error) {
+	r.Lock()
+	defer r.Unlock()
+	r.closed = append(r.closed, conn)
+}
+
+func (r *recordingTracer) SamplePayload(FrameInfo) bool {
+	return true
+}
+
+func (r *recordingTracer) FramePayload(_ FrameInfo, read bool, payload []byte) {
+	r.Lock()
+	defer r.Unlock()
+	if !read {
+		r.payloadsWritten = append(r.payloadsWritten, append([]byte(nil), payload...))
+	}
+}
+
+func TestServer_Tracer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverAddress, server, err := newTestServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := &recordingTracer{}
+	server.Tracer = tracer
+	if err := newTestClient(ctx, "ws://"+serverAddress); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.WaitForSession(ctx, "client"); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conn, err := server.Dialer("client")(ctx, "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tracer.Lock()
+		done := len(tracer.closed) > 0
+		tracer.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tracer.Lock()
+	defer tracer.Unlock()
+	if len(tracer.opened) != 1 || tracer.opened[0].Address != listener.Addr().String() {
+		t.Errorf("unexpected opened connections: %+v", tracer.opened)
+	}
+	if len(tracer.closed) != 1 {
+		t.Errorf("unexpected closed connections: %+v", tracer.closed)
+	}
+	if len(tracer.written) < 2 || tracer.written[0].Type != "CONNECT" || tracer.written[1].Type != "DATA" || tracer.written[1].Size != len("hello") {
+		t.Errorf("unexpected written frames: %+v", tracer.written)
+	}
+	if len(tracer.payloadsWritten) != 1 || string(tracer.payloadsWritten[0]) != "hello" {
+		t.Errorf("unexpected payloads: %q", tracer.payloadsWritten)
+	}
+}
+
+func TestRedactCredentials(t *testing.T) {
+	t.Parallel()
+
+	payload := "GET /api HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer secret-token\r\ncookie: session=secret\r\n\r\n"
+	redacted := string(RedactCredentials([]byte(payload)))
+	if strings.Contains(redacted, "secret") {
+		t.Errorf("credentials were not redacted: %q", redacted)
+	}
+	if !strings.Contains(redacted, "Host: localhost\r\nAuthorization: [REDACTED]\r\n") {
+		t.Errorf("unexpected redacted payload: %q", redacted)
+	}
+}
+
+func TestMessageStringOmitsPayload(t *testing.T) {
+	t.Parallel()
+
+	if s := newMessage(1, []byte("Authorization: Bearer secret")).String(); strings.Contains(s, "secret") {
+		t.Errorf("message string contains the payload: %q", s)
+	}
+}
@@ -2,9 +2,11 @@ package remotedialer
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -15,6 +17,8 @@ type wsWrapper struct {
 	sync.Mutex
 	// conn is the underlying websocket connection
 	conn *websocket.Conn
+	// rtt is the last round trip time measured from a ping, in nanoseconds
+	rtt atomic.Int64
 }
 
 func newWSConn(conn *websocket.Conn) *wsWrapper {
@@ -34,6 +38,8 @@ type wsConn interface {
 	WriteControl(messageType int, deadline time.Time, data []byte) error
 	// WriteMessage writes a new websocket data frame, see https://datatracker.ietf.org/doc/html/rfc6455#section-6
 	WriteMessage(messageType int, deadline time.Time, data []byte) error
+	// RTT returns the last round trip time measured from a ping, or zero if none is available
+	RTT() time.Duration
 }
 
 func (w *wsWrapper) WriteControl(messageType int, deadline time.Time, data []byte) error {
@@ -72,15 +78,20 @@ func (w *wsWrapper) NextReader() (int, io.Reader, error) {
 	return w.conn.NextReader()
 }
 
+func (w *wsWrapper) RTT() time.Duration {
+	return time.Duration(w.rtt.Load())
+}
+
 func (w *wsWrapper) Close() error {
 	return w.conn.Close()
 }
 
 func (w *wsWrapper) setupDeadline() {
 	w.conn.SetReadDeadline(time.Now().Add(PingWaitDuration))
-	w.conn.SetPingHandler(func(string) error {
+	w.conn.SetPingHandler(func(appData string) error {
 		w.Lock()
-		err := w.conn.WriteControl(websocket.PongMessage, []byte(""), time.Now().Add(PingWaitDuration))
+		// echo the ping payload, so the remote end can measure the round trip time
+		err := w.conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(PingWaitDuration))
 		w.Unlock()
 		if err != nil {
 			return err
@@ -90,7 +101,12 @@ func (w *wsWrapper) setupDeadline() {
 		}
 		return w.conn.SetWriteDeadline(time.Now().Add(PingWaitDuration))
 	})
-	w.conn.SetPongHandler(func(string) error {
+	w.conn.SetPongHandler(func(appData string) error {
+		if sent, ok := decodePingTime([]byte(appData)); ok {
+			if rtt := time.Since(sent); rtt >= 0 {
+				w.rtt.Store(int64(rtt))
+			}
+		}
 		if err := w.conn.SetReadDeadline(time.Now().Add(PingWaitDuration)); err != nil {
 			return err
 		}
@@ -98,3 +114,17 @@ func (w *wsWrapper) setupDeadline() {
 	})
 
 }
+
+// encodePingTime serializes a timestamp to be used as ping payload
+func encodePingTime(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
+
+// decodePingTime deserializes a timestamp from a pong payload.
+// Remote ends that don't echo the ping payload send empty pongs, which are ignored.
+func decodePingTime(payload []byte) (time.Time, bool) {
+	if len(payload) != 8 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(payload))), true
+}
@@ -25,6 +25,10 @@ func (f fakeWSConn) WriteMessage(messageType int, deadline time.Time, data []byt
 	return errors.New("callback not provided")
 }
 
+func (f fakeWSConn) RTT() time.Duration {
+	return 0
+}
+
 func (f fakeWSConn) WriteControl(int, time.Time, []byte) error {
 	return errors.New("not implemented")
 }